@@ -0,0 +1,53 @@
+package entity
+
+import "time"
+
+const (
+	JobStatusQueued      = "queued"
+	JobStatusDownloading = "downloading" // fetching the source from storage
+	JobStatusProbing     = "probing"
+	JobStatusEncoding    = "encoding"
+	JobStatusUploading   = "uploading"
+	JobStatusDone        = "done"
+	JobStatusFailed      = "failed"
+)
+
+type Job struct {
+	ID        string     `json:"id"`
+	VideoID   string     `json:"video_id"`
+	Profile   string     `json:"profile"`
+	Status    string     `json:"status"`
+	Variant   string     `json:"variant,omitempty"` // rendition currently being encoded
+	Error     string     `json:"error,omitempty"`
+	Probe     *JobProbe  `json:"probe,omitempty"`
+	Events    []JobEvent `json:"events"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// JobEvent records a single state transition of a job.
+type JobEvent struct {
+	Status  string    `json:"status"`
+	Variant string    `json:"variant,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// JobProbe holds the source properties read by ffprobe before encoding.
+type JobProbe struct {
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	DisplayWidth  int     `json:"display_width"`
+	DisplayHeight int     `json:"display_height"`
+	Rotation      int     `json:"rotation"`
+	FPS           float64 `json:"fps"`
+	DurationMs    int64   `json:"duration_ms"`
+}
+
+func IsValidJobStatus(status string) bool {
+	switch status {
+	case JobStatusQueued, JobStatusDownloading, JobStatusProbing, JobStatusEncoding, JobStatusUploading, JobStatusDone, JobStatusFailed:
+		return true
+	}
+	return false
+}
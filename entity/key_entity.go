@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// ContentKey is an AES-128 content key wrapped with a master key, stored away
+// from the media bucket.
+type ContentKey struct {
+	VideoID     string    `json:"video_id"`
+	Name        string    `json:"name"` // e.g. "enc_720p.key"
+	MasterKeyID string    `json:"master_key_id"`
+	Nonce       []byte    `json:"nonce"`
+	Ciphertext  []byte    `json:"ciphertext"`
+	CreatedAt   time.Time `json:"created_at"`
+}
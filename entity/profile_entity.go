@@ -0,0 +1,75 @@
+package entity
+
+const (
+	SegmentFormatTS   = "ts"
+	SegmentFormatFMP4 = "fmp4" // CMAF fragmented MP4 with an init segment
+)
+
+const (
+	CodecFamilyH264 = "h264"
+	CodecFamilyHEVC = "hevc"
+	CodecFamilyAV1  = "av1"
+)
+
+// codecFamilies maps the ffmpeg encoders a profile may use to the bitstream
+// format they produce.
+var codecFamilies = map[string]string{
+	"libx264":           CodecFamilyH264,
+	"h264_nvenc":        CodecFamilyH264,
+	"h264_qsv":          CodecFamilyH264,
+	"h264_videotoolbox": CodecFamilyH264,
+	"libx265":           CodecFamilyHEVC,
+	"hevc_nvenc":        CodecFamilyHEVC,
+	"hevc_qsv":          CodecFamilyHEVC,
+	"hevc_videotoolbox": CodecFamilyHEVC,
+	"libsvtav1":         CodecFamilyAV1,
+	"libaom-av1":        CodecFamilyAV1,
+	"librav1e":          CodecFamilyAV1,
+	"av1_nvenc":         CodecFamilyAV1,
+}
+
+// CodecFamily returns the format produced by an ffmpeg encoder, or an empty
+// string for encoders the pipeline does not know.
+func CodecFamily(encoder string) string {
+	return codecFamilies[encoder]
+}
+
+// EncodingProfile is a named set of encoder settings selectable per upload.
+type EncodingProfile struct {
+	Name            string       `json:"name" yaml:"-"`
+	Codec           string       `json:"codec" yaml:"codec"`
+	Preset          string       `json:"preset" yaml:"preset"`
+	GOPSeconds      float64      `json:"gop_seconds" yaml:"gop_seconds"`
+	SegmentDuration int          `json:"segment_duration" yaml:"segment_duration"`
+	SegmentFormat   string       `json:"segment_format" yaml:"segment_format"`
+	AudioBitrate    string       `json:"audio_bitrate" yaml:"audio_bitrate"`
+	Encryption      bool         `json:"encryption" yaml:"encryption"`
+	KeyRotation     int          `json:"key_rotation_segments" yaml:"key_rotation_segments"` // new AES-128 key every N segments, 0 for one key per rendition
+	SinglePass      bool         `json:"single_pass" yaml:"single_pass"`                     // decode once and emit every rung from one ffmpeg process
+	DASH            bool         `json:"dash" yaml:"dash"`                                   // also write an MPD referencing the CMAF segments
+	SeparateAudio   bool         `json:"separate_audio" yaml:"separate_audio"`               // audio renditions per source track instead of muxed audio
+	IFrames         bool         `json:"iframe_playlists" yaml:"iframe_playlists"`           // also write I-frame playlists for trick play
+	Ladder          []LadderRung `json:"ladder" yaml:"ladder"`
+}
+
+// LadderRung is one rendition of a profile. Size is the length of the shorter
+// frame side, so a vertical 720p rendition is 720 pixels wide.
+type LadderRung struct {
+	Label   string `json:"label" yaml:"label"`
+	Size    int    `json:"size" yaml:"size"`
+	Bitrate string `json:"bitrate" yaml:"bitrate"`
+	Codec   string `json:"codec" yaml:"codec"` // defaults to the profile codec
+}
+
+// Encoders lists every ffmpeg encoder the profile needs.
+func (p *EncodingProfile) Encoders() []string {
+	seen := map[string]bool{p.Codec: true}
+	encoders := []string{p.Codec}
+	for _, rung := range p.Ladder {
+		if !seen[rung.Codec] {
+			seen[rung.Codec] = true
+			encoders = append(encoders, rung.Codec)
+		}
+	}
+	return encoders
+}
@@ -0,0 +1,22 @@
+package entity
+
+import "time"
+
+// Upload is an upload in progress. For resumable uploads the received bytes
+// are kept on disk and the record tracks how many of them are committed.
+// Direct uploads go straight to the object store at ObjectKey instead.
+type Upload struct {
+	ID               string    `json:"id"`
+	Length           int64     `json:"length"`
+	Offset           int64     `json:"offset"`
+	VideoID          string    `json:"video_id"` // assigned when the upload is created
+	OriginalFilename string    `json:"original_filename"`
+	Profile          string    `json:"profile"`
+	Title            string    `json:"title,omitempty"`
+	Owner            string    `json:"owner,omitempty"`
+	ObjectKey        string    `json:"object_key,omitempty"` // set for direct uploads
+	Completed        bool      `json:"completed"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
@@ -1,5 +1,34 @@
 package entity
 
+import "time"
+
+const (
+	VideoStatusProcessing = "processing"
+	VideoStatusReady      = "ready"
+	VideoStatusFailed     = "failed"
+)
+
 type Video struct {
-	Dir string // e.g. "courses/123/video456"
+	ID               string           `json:"id"`
+	Title            string           `json:"title"`
+	Description      string           `json:"description,omitempty"`
+	Tags             []string         `json:"tags,omitempty"`
+	Owner            string           `json:"owner,omitempty"`
+	OriginalFilename string           `json:"original_filename,omitempty"`
+	Dir              string           `json:"dir"` // storage prefix, e.g. "courses/123/video456"
+	Status           string           `json:"status"`
+	DurationMs       int64            `json:"duration_ms"`
+	Renditions       []VideoRendition `json:"renditions"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+}
+
+// VideoRendition is one variant stream of an encoded video as listed in its
+// master playlist.
+type VideoRendition struct {
+	Label     string `json:"label"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Bandwidth int    `json:"bandwidth"`
+	Codecs    string `json:"codecs"`
 }
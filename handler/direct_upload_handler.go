@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/usecase"
+	"ffmpeg-hls/worker"
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type DirectUploadHandler interface {
+	CreateDirectUpload(ctx *fiber.Ctx) error
+	CompleteDirectUpload(ctx *fiber.Ctx) error
+}
+
+type directUploadHandler struct {
+	uploadUseCase usecase.UploadUseCase
+	encodeUseCase usecase.EncodeUseCase
+	encodeWorker  worker.EncodeWorker
+}
+
+func NewDirectUploadHandler(uploadUseCase usecase.UploadUseCase, encodeUseCase usecase.EncodeUseCase, encodeWorker worker.EncodeWorker) DirectUploadHandler {
+	return &directUploadHandler{
+		uploadUseCase: uploadUseCase,
+		encodeUseCase: encodeUseCase,
+		encodeWorker:  encodeWorker,
+	}
+}
+
+func (h *directUploadHandler) CreateDirectUpload(ctx *fiber.Ctx) error {
+	request := new(model.CreateDirectUploadRequest)
+	if err := ctx.BodyParser(request); err != nil {
+		log.Printf("[CLIENT ERROR] [CREATE DIRECT UPLOAD] body parser error : %v", err)
+		return fiber.NewError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	response, err := h.uploadUseCase.CreateDirectUpload(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	playback := h.encodeUseCase.PlaybackURLs(ctx.Context(), &model.EncodeRequest{
+		APIServer: apiServerURL(),
+		VideoID:   response.VideoID,
+		Profile:   request.Profile,
+	})
+
+	return ctx.Status(http.StatusCreated).JSON(fiber.Map{
+		"Success":  true,
+		"upload":   response,
+		"playback": playback,
+	})
+}
+
+// CompleteDirectUpload is called by the client once its PUT succeeded and
+// queues the encode job the usecase created, which downloads the source
+// from storage.
+func (h *directUploadHandler) CompleteDirectUpload(ctx *fiber.Ctx) error {
+	response, err := h.uploadUseCase.CompleteDirectUpload(ctx.Context(), &model.UploadRequest{UploadID: ctx.Params("uploadID")})
+	if err != nil {
+		return err
+	}
+
+	encodeRequest := response.Encode
+	encodeRequest.APIServer = apiServerURL()
+
+	go func() {
+		h.encodeWorker.SendJobToWorker(encodeRequest)
+	}()
+
+	return ctx.Status(http.StatusOK).JSON(fiber.Map{
+		"Success": true,
+		"upload":  response,
+		"video": &model.UploadVideoResponse{
+			VideoID:          encodeRequest.VideoID,
+			OriginalFilename: encodeRequest.OriginalFilename,
+			Playback:         h.encodeUseCase.PlaybackURLs(ctx.Context(), encodeRequest),
+			Job:              response.Job,
+		},
+		"job": response.Job,
+	})
+}
@@ -14,16 +14,21 @@ import (
 
 type EncodeHandler interface {
 	UploadVideo(ctx *fiber.Ctx) error
+	ImportVideo(ctx *fiber.Ctx) error
 }
 
 type encodeHandler struct {
 	encodeUseCase usecase.EncodeUseCase
+	importUseCase usecase.ImportUseCase
+	jobUseCase    usecase.JobUseCase
 	encodeWorker  worker.EncodeWorker
 }
 
-func NewEncodeHandler(encodeUseCase usecase.EncodeUseCase, encodeWorker worker.EncodeWorker) EncodeHandler {
+func NewEncodeHandler(encodeUseCase usecase.EncodeUseCase, importUseCase usecase.ImportUseCase, jobUseCase usecase.JobUseCase, encodeWorker worker.EncodeWorker) EncodeHandler {
 	return &encodeHandler{
 		encodeUseCase: encodeUseCase,
+		importUseCase: importUseCase,
+		jobUseCase:    jobUseCase,
 		encodeWorker:  encodeWorker,
 	}
 }
@@ -35,22 +40,58 @@ func (h *encodeHandler) UploadVideo(ctx *fiber.Ctx) error {
 		return fiber.NewError(http.StatusUnprocessableEntity, "Invalid video file, make sure you have ti provide the required video")
 	}
 
+	encodeRequest := &model.EncodeRequest{
+		OriginalFilename: video.Filename,
+		Profile:          ctx.FormValue("profile"),
+		Title:            ctx.FormValue("title"),
+		Owner:            ctx.FormValue("owner"),
+	}
+
+	if err := h.encodeUseCase.ValidateRequest(ctx.Context(), encodeRequest); err != nil {
+		return err
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Printf("failed to get current directory: %v", err)
 		return fiber.NewError(http.StatusInternalServerError, "internal error")
 	}
 
-	savePath := filepath.Join(cwd, "usecase", "tmp", video.Filename)
+	// The source is stored under the generated ID, never the client name.
+	savePath := filepath.Join(cwd, "usecase", "tmp", encodeRequest.VideoID)
 	if err := ctx.SaveFile(video, savePath); err != nil {
 		log.Printf("[INTERNAL ERROR] [UPLOAD VIDEO] save video error : %v", err)
 		return fiber.NewError(http.StatusServiceUnavailable, "Something wrong please try again later.")
 	}
 
-	serverKey := os.Getenv("HTTP_PROTOCOL") + os.Getenv("BASE_IP_URL") + ":" + os.Getenv("PORT")
-	encodeRequest := &model.EncodeRequest{
-		APIServer: serverKey,
-		VideoID:   video.Filename,
+	return h.startEncode(ctx, encodeRequest)
+}
+
+// ImportVideo queues a job that downloads a source hosted elsewhere and
+// encodes it like an uploaded one.
+func (h *encodeHandler) ImportVideo(ctx *fiber.Ctx) error {
+	request := new(model.ImportVideoRequest)
+	if err := ctx.BodyParser(request); err != nil {
+		log.Printf("[CLIENT ERROR] [IMPORT VIDEO] body parser error : %v", err)
+		return fiber.NewError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	encodeRequest, err := h.importUseCase.ImportVideo(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return h.startEncode(ctx, encodeRequest)
+}
+
+// startEncode queues the job for a source saved in the encoder input, or
+// one the job downloads first, and responds with where the video will play.
+func (h *encodeHandler) startEncode(ctx *fiber.Ctx, encodeRequest *model.EncodeRequest) error {
+	encodeRequest.APIServer = apiServerURL()
+
+	job, err := h.jobUseCase.CreateJob(ctx.Context(), encodeRequest)
+	if err != nil {
+		return err
 	}
 
 	go func() {
@@ -59,6 +100,18 @@ func (h *encodeHandler) UploadVideo(ctx *fiber.Ctx) error {
 
 	return ctx.Status(http.StatusOK).JSON(fiber.Map{
 		"Success": true,
+		"video": &model.UploadVideoResponse{
+			VideoID:          encodeRequest.VideoID,
+			OriginalFilename: encodeRequest.OriginalFilename,
+			Playback:         h.encodeUseCase.PlaybackURLs(ctx.Context(), encodeRequest),
+			Job:              job,
+		},
+		"job": job,
 	})
+}
 
+// apiServerURL is the public address of this API, used in playback and key
+// URLs.
+func apiServerURL() string {
+	return os.Getenv("HTTP_PROTOCOL") + os.Getenv("BASE_IP_URL") + ":" + os.Getenv("PORT")
 }
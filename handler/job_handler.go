@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/usecase"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const sseKeepAliveInterval = 15 * time.Second
+
+type JobHandler interface {
+	GetJob(ctx *fiber.Ctx) error
+	ListJobs(ctx *fiber.Ctx) error
+	JobEvents(ctx *fiber.Ctx) error
+}
+
+type jobHandler struct {
+	jobUseCase usecase.JobUseCase
+}
+
+func NewJobHandler(jobUseCase usecase.JobUseCase) JobHandler {
+	return &jobHandler{jobUseCase: jobUseCase}
+}
+
+func (h *jobHandler) GetJob(ctx *fiber.Ctx) error {
+	request := &model.GetJobRequest{
+		JobID: ctx.Params("id"),
+	}
+
+	response, err := h.jobUseCase.GetJob(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(http.StatusOK).JSON(response)
+}
+
+func (h *jobHandler) ListJobs(ctx *fiber.Ctx) error {
+	request := &model.ListJobsRequest{
+		Status: ctx.Query("status"),
+	}
+
+	response, err := h.jobUseCase.ListJobs(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(http.StatusOK).JSON(response)
+}
+
+// JobEvents streams job progress as Server-Sent Events until the job reaches
+// a terminal state or the client disconnects.
+func (h *jobHandler) JobEvents(ctx *fiber.Ctx) error {
+	request := &model.GetJobRequest{
+		JobID: ctx.Params("id"),
+	}
+
+	// Subscribe before reading the job so no transition is missed in between.
+	events, unsubscribe := h.jobUseCase.Subscribe(request.JobID)
+
+	job, err := h.jobUseCase.GetJob(ctx.Context(), request)
+	if err != nil {
+		unsubscribe()
+		return err
+	}
+
+	ctx.Set("Content-Type", "text/event-stream")
+	ctx.Set("Cache-Control", "no-cache")
+	ctx.Set("Connection", "keep-alive")
+	ctx.Set("X-Accel-Buffering", "no")
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		// Always open with the persisted state so finished jobs still get one event.
+		initial := &model.JobProgressEvent{
+			JobID:   job.ID,
+			Status:  job.Status,
+			Variant: job.Variant,
+			Error:   job.Error,
+		}
+		if job.Status == entity.JobStatusDone {
+			initial.Percent = 100
+		}
+		if err := writeSSE(w, initial); err != nil || isTerminalJobStatus(job.Status) {
+			return
+		}
+
+		keepAlive := time.NewTicker(sseKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case event := <-events:
+				if err := writeSSE(w, event); err != nil {
+					return
+				}
+				if isTerminalJobStatus(event.Status) {
+					return
+				}
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+func writeSSE(w *bufio.Writer, event *model.JobProgressEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[HANDLER][JobEvents] marshal event: %v", err)
+		return err
+	}
+
+	fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+	return w.Flush()
+}
+
+func isTerminalJobStatus(status string) bool {
+	return status == entity.JobStatusDone || status == entity.JobStatusFailed
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LimitBody buffers the request body and rejects it with 413 once it is
+// larger than limit. The server streams request bodies so that uploads can
+// be written straight to disk, every other route must sit behind LimitBody
+// so that it never reads an unbounded body into memory.
+func LimitBody(limit int) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		request := ctx.Request()
+		if request.Header.ContentLength() > limit {
+			return fiber.ErrRequestEntityTooLarge
+		}
+
+		if stream := request.BodyStream(); stream != nil {
+			body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+			if err != nil {
+				return fiber.ErrBadRequest
+			}
+			if len(body) > limit {
+				return fiber.ErrRequestEntityTooLarge
+			}
+			request.SetBody(body)
+		}
+
+		return ctx.Next()
+	}
+}
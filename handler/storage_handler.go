@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"ffmpeg-hls/util"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type StorageHandler interface {
+	ServeObject(ctx *fiber.Ctx) error
+	ReceiveObject(ctx *fiber.Ctx) error
+}
+
+type storageHandler struct {
+	localStore *util.LocalStore
+}
+
+// NewStorageHandler serves the presigned URLs of the local object store.
+func NewStorageHandler(localStore *util.LocalStore) StorageHandler {
+	return &storageHandler{localStore: localStore}
+}
+
+func (h *storageHandler) ServeObject(ctx *fiber.Ctx) error {
+	key, err := url.PathUnescape(ctx.Params("*"))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, "Invalid object key")
+	}
+
+	if err := h.localStore.VerifyPresigned(http.MethodGet, key, "", ctx.Query("expires"), ctx.Query("signature"), time.Now()); err != nil {
+		log.Printf("[CLIENT ERROR] [SERVE OBJECT] verify error : %v", err)
+		return fiber.NewError(http.StatusForbidden, "Invalid or expired URL")
+	}
+
+	path, err := h.localStore.Path(key)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, "Invalid object key")
+	}
+	if info, err := os.Stat(path); errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return fiber.NewError(http.StatusNotFound, "Requested file not found")
+	}
+
+	// SendFile answers Range requests, which byte range playlists rely on.
+	if err := ctx.SendFile(path); err != nil {
+		log.Printf("[INTERNAL ERROR] [SERVE OBJECT] send file error : %v", err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+	ctx.Set(fiber.HeaderContentType, util.ObjectContentType(key))
+	ctx.Set(fiber.HeaderCacheControl, util.ObjectCacheControl(key))
+	return nil
+}
+
+// ReceiveObject stores the body of a presigned PUT, as S3 does for direct
+// uploads.
+func (h *storageHandler) ReceiveObject(ctx *fiber.Ctx) error {
+	key, err := url.PathUnescape(ctx.Params("*"))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, "Invalid object key")
+	}
+
+	if err := h.localStore.VerifyPresigned(http.MethodPut, key, ctx.Query("size"), ctx.Query("expires"), ctx.Query("signature"), time.Now()); err != nil {
+		log.Printf("[CLIENT ERROR] [RECEIVE OBJECT] verify error : %v", err)
+		return fiber.NewError(http.StatusForbidden, "Invalid or expired URL")
+	}
+
+	// The URL was issued for a body of this size, as S3 checks the signed
+	// Content-Length.
+	size := int64(ctx.Request().Header.ContentLength())
+	if strconv.FormatInt(size, 10) != ctx.Query("size") {
+		return fiber.NewError(http.StatusBadRequest, "Content-Length must be the size the upload URL was issued for")
+	}
+
+	var body io.Reader = ctx.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(ctx.Body())
+	}
+
+	if err := h.localStore.PutObject(ctx.Context(), key, body, size, util.ObjectPutOptions(key)); err != nil {
+		log.Printf("[INTERNAL ERROR] [RECEIVE OBJECT] put object error : %v", err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	return ctx.SendStatus(http.StatusOK)
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"bytes"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/usecase"
+	"ffmpeg-hls/util"
+	"ffmpeg-hls/worker"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	headerTusResumable  = "Tus-Resumable"
+	headerTusVersion    = "Tus-Version"
+	headerTusExtension  = "Tus-Extension"
+	headerTusMaxSize    = "Tus-Max-Size"
+	headerUploadLength  = "Upload-Length"
+	headerUploadOffset  = "Upload-Offset"
+	headerUploadExpires = "Upload-Expires"
+	headerUploadMeta    = "Upload-Metadata"
+
+	tusContentType = "application/offset+octet-stream"
+)
+
+// TusHeaders are the request and response headers browsers must be allowed
+// to send and read for tus clients to work cross-origin.
+var (
+	TusRequestHeaders  = []string{headerTusResumable, headerUploadLength, headerUploadOffset, headerUploadMeta}
+	TusResponseHeaders = []string{fiber.HeaderLocation, headerTusResumable, headerTusVersion, headerTusExtension, headerTusMaxSize, headerUploadLength, headerUploadOffset, headerUploadExpires}
+)
+
+type TusHandler interface {
+	Options(ctx *fiber.Ctx) error
+	CreateUpload(ctx *fiber.Ctx) error
+	HeadUpload(ctx *fiber.Ctx) error
+	PatchUpload(ctx *fiber.Ctx) error
+	DeleteUpload(ctx *fiber.Ctx) error
+}
+
+type tusHandler struct {
+	uploadUseCase usecase.UploadUseCase
+	encodeUseCase usecase.EncodeUseCase
+	encodeWorker  worker.EncodeWorker
+	maxSize       int64
+}
+
+func NewTusHandler(uploadUseCase usecase.UploadUseCase, encodeUseCase usecase.EncodeUseCase, encodeWorker worker.EncodeWorker, maxSize int64) TusHandler {
+	return &tusHandler{
+		uploadUseCase: uploadUseCase,
+		encodeUseCase: encodeUseCase,
+		encodeWorker:  encodeWorker,
+		maxSize:       maxSize,
+	}
+}
+
+func (h *tusHandler) Options(ctx *fiber.Ctx) error {
+	ctx.Set(headerTusResumable, util.TusVersion)
+	ctx.Set(headerTusVersion, util.TusVersion)
+	ctx.Set(headerTusExtension, util.TusExtensions)
+	ctx.Set(headerTusMaxSize, strconv.FormatInt(h.maxSize, 10))
+	return ctx.SendStatus(http.StatusNoContent)
+}
+
+func (h *tusHandler) CreateUpload(ctx *fiber.Ctx) error {
+	if err := checkTusResumable(ctx); err != nil {
+		return err
+	}
+
+	length, err := strconv.ParseInt(ctx.Get(headerUploadLength), 10, 64)
+	if err != nil {
+		log.Printf("[CLIENT ERROR] [CREATE UPLOAD] upload length error : %v", err)
+		return fiber.NewError(http.StatusBadRequest, "Invalid Upload-Length, deferred lengths are not supported")
+	}
+
+	metadata, err := util.ParseTusMetadata(ctx.Get(headerUploadMeta))
+	if err != nil {
+		log.Printf("[CLIENT ERROR] [CREATE UPLOAD] metadata error : %v", err)
+		return fiber.NewError(http.StatusBadRequest, "Invalid Upload-Metadata")
+	}
+
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"] // tus-js-client's default key for File uploads
+	}
+
+	request := &model.CreateUploadRequest{
+		Length:   length,
+		Filename: filename,
+		Profile:  metadata["profile"],
+		Title:    metadata["title"],
+		Owner:    metadata["owner"],
+	}
+
+	response, err := h.uploadUseCase.CreateUpload(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	location := apiServerURL() + "/video/uploads/" + response.ID
+	ctx.Set(fiber.HeaderLocation, location)
+	ctx.Set(headerUploadExpires, response.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	playback := h.encodeUseCase.PlaybackURLs(ctx.Context(), &model.EncodeRequest{
+		APIServer: apiServerURL(),
+		VideoID:   response.VideoID,
+		Profile:   request.Profile,
+	})
+
+	return ctx.Status(http.StatusCreated).JSON(fiber.Map{
+		"Success":  true,
+		"upload":   response,
+		"playback": playback,
+	})
+}
+
+func (h *tusHandler) HeadUpload(ctx *fiber.Ctx) error {
+	if err := checkTusResumable(ctx); err != nil {
+		return err
+	}
+
+	response, err := h.uploadUseCase.GetUpload(ctx.Context(), &model.UploadRequest{UploadID: ctx.Params("uploadID")})
+	if err != nil {
+		return err
+	}
+
+	if response.Encode != nil {
+		h.enqueue(response.Encode)
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	ctx.Set(headerUploadOffset, strconv.FormatInt(response.Offset, 10))
+	ctx.Set(headerUploadLength, strconv.FormatInt(response.Length, 10))
+	ctx.Set(headerUploadExpires, response.ExpiresAt.UTC().Format(http.TimeFormat))
+	return ctx.SendStatus(http.StatusOK)
+}
+
+func (h *tusHandler) PatchUpload(ctx *fiber.Ctx) error {
+	if err := checkTusResumable(ctx); err != nil {
+		return err
+	}
+
+	if ctx.Get(fiber.HeaderContentType) != tusContentType {
+		return fiber.NewError(http.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType)
+	}
+
+	offset, err := strconv.ParseInt(ctx.Get(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		return fiber.NewError(http.StatusBadRequest, "Invalid Upload-Offset")
+	}
+
+	// Large chunks are streamed from the connection instead of buffered,
+	// see StreamRequestBody in main.
+	var body io.Reader = ctx.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(ctx.Body())
+	}
+
+	request := &model.AppendUploadRequest{
+		UploadID:      ctx.Params("uploadID"),
+		Offset:        offset,
+		ContentLength: max(int64(ctx.Request().Header.ContentLength()), -1),
+		Body:          body,
+	}
+
+	response, err := h.uploadUseCase.AppendUpload(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	if response.Encode != nil {
+		h.enqueue(response.Encode)
+	}
+
+	ctx.Set(headerUploadOffset, strconv.FormatInt(response.Offset, 10))
+	ctx.Set(headerUploadExpires, response.ExpiresAt.UTC().Format(http.TimeFormat))
+	return ctx.SendStatus(http.StatusNoContent)
+}
+
+// enqueue sends a completed upload, whose job the usecase created, to the
+// encoder.
+func (h *tusHandler) enqueue(encodeRequest *model.EncodeRequest) {
+	encodeRequest.APIServer = apiServerURL()
+
+	go func() {
+		h.encodeWorker.SendJobToWorker(encodeRequest)
+	}()
+}
+
+func (h *tusHandler) DeleteUpload(ctx *fiber.Ctx) error {
+	if err := checkTusResumable(ctx); err != nil {
+		return err
+	}
+
+	if err := h.uploadUseCase.TerminateUpload(ctx.Context(), &model.UploadRequest{UploadID: ctx.Params("uploadID")}); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(http.StatusNoContent)
+}
+
+// checkTusResumable rejects clients speaking another protocol version and
+// marks the response with the version in use.
+func checkTusResumable(ctx *fiber.Ctx) error {
+	ctx.Set(headerTusResumable, util.TusVersion)
+	if ctx.Get(headerTusResumable) != util.TusVersion {
+		ctx.Set(headerTusVersion, util.TusVersion)
+		return fiber.NewError(http.StatusPreconditionFailed, "Unsupported Tus-Resumable version")
+	}
+	return nil
+}
@@ -5,6 +5,10 @@ import (
 	"ffmpeg-hls/model"
 	"ffmpeg-hls/usecase"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,6 +17,14 @@ import (
 type VideoHandler interface {
 	VideoKey(ctx *fiber.Ctx) error
 	VideoManifest(ctx *fiber.Ctx) error
+	VideoDashManifest(ctx *fiber.Ctx) error
+	UploadSubtitle(ctx *fiber.Ctx) error
+	VideoThumbnails(ctx *fiber.Ctx) error
+	IssuePlaybackToken(ctx *fiber.Ctx) error
+	ListVideos(ctx *fiber.Ctx) error
+	GetVideo(ctx *fiber.Ctx) error
+	UpdateVideo(ctx *fiber.Ctx) error
+	DeleteVideo(ctx *fiber.Ctx) error
 }
 
 type videoHandler struct {
@@ -30,6 +42,8 @@ func (h *videoHandler) VideoManifest(ctx *fiber.Ctx) error {
 	request := &model.VideoManifestRequest{
 		VideoID:  videoID,
 		Playlist: playlist,
+		Token:    ctx.Query("token"),
+		ClientIP: ctx.IP(),
 	}
 
 	response, err := h.videoUseCase.VideoManifest(ctx.Context(), request)
@@ -41,13 +55,90 @@ func (h *videoHandler) VideoManifest(ctx *fiber.Ctx) error {
 	return ctx.SendString(strings.Join(response, "\n"))
 }
 
+func (h *videoHandler) VideoDashManifest(ctx *fiber.Ctx) error {
+	request := &model.VideoDashManifestRequest{
+		VideoID: ctx.Params("videoID"),
+		Name:    ctx.Params("name"), // e.g. "manifest" for manifest.mpd
+	}
+
+	response, err := h.videoUseCase.VideoDashManifest(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	ctx.Type("application/dash+xml", "utf-8")
+	return ctx.Send(response)
+}
+
+func (h *videoHandler) VideoThumbnails(ctx *fiber.Ctx) error {
+	request := &model.VideoThumbnailsRequest{
+		VideoID: ctx.Params("videoID"),
+	}
+
+	response, err := h.videoUseCase.VideoThumbnails(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	ctx.Type("text/vtt", "utf-8")
+	return ctx.Send(response)
+}
+
+// subtitleExtensions are the caption formats accepted by UploadSubtitle.
+var subtitleExtensions = map[string]bool{".srt": true, ".vtt": true}
+
+func (h *videoHandler) UploadSubtitle(ctx *fiber.Ctx) error {
+	file, err := ctx.FormFile("subtitle")
+	if err != nil {
+		log.Printf("[CLIENT ERROR] [UPLOAD SUBTITLE] form file error : %v", err)
+		return fiber.NewError(http.StatusUnprocessableEntity, "Invalid subtitle file, make sure you provide an SRT or WebVTT file")
+	}
+
+	if !subtitleExtensions[strings.ToLower(filepath.Ext(file.Filename))] {
+		return fiber.NewError(http.StatusUnprocessableEntity, "Invalid subtitle file, make sure you provide an SRT or WebVTT file")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		log.Printf("[INTERNAL ERROR] [UPLOAD SUBTITLE] open file error : %v", err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		log.Printf("[INTERNAL ERROR] [UPLOAD SUBTITLE] read file error : %v", err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	request := &model.SubtitleUploadRequest{
+		VideoID:  ctx.Params("videoID"),
+		Language: ctx.FormValue("language"),
+		Name:     ctx.FormValue("name"),
+		Default:  ctx.FormValue("default") == "true",
+		Data:     data,
+	}
+
+	response, err := h.videoUseCase.UploadSubtitle(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(http.StatusCreated).JSON(fiber.Map{
+		"Success":  true,
+		"subtitle": response,
+	})
+}
+
 func (h *videoHandler) VideoKey(ctx *fiber.Ctx) error {
 	videoID := ctx.Params("videoID")
 	key := ctx.Params("key") // e.g. "360p.m3u8" or "master.m3u8"
 
 	request := &model.VideoKeyRequest{
-		VideoID: videoID,
-		KeyName: key,
+		VideoID:  videoID,
+		KeyName:  key,
+		Token:    playbackToken(ctx),
+		ClientIP: ctx.IP(),
 	}
 
 	response, err := h.videoUseCase.VideoKey(ctx.Context(), request)
@@ -59,3 +150,90 @@ func (h *videoHandler) VideoKey(ctx *fiber.Ctx) error {
 	ctx.Response().Header.Set("Content-Length", fmt.Sprintf("%d", len(response)))
 	return ctx.SendStream(bytes.NewReader(response)) // or c.Send(data) if prefered
 }
+
+func (h *videoHandler) IssuePlaybackToken(ctx *fiber.Ctx) error {
+	request := new(model.PlaybackTokenRequest)
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(request); err != nil {
+			log.Printf("[CLIENT ERROR] [ISSUE TOKEN] body parser error : %v", err)
+			return fiber.NewError(http.StatusBadRequest, "Invalid request body")
+		}
+	}
+	request.VideoID = ctx.Params("videoID")
+	request.Session = strings.TrimPrefix(ctx.Get(fiber.HeaderAuthorization), "Bearer ")
+	request.ClientIP = ctx.IP()
+
+	response, err := h.videoUseCase.IssuePlaybackToken(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(http.StatusCreated).JSON(fiber.Map{
+		"Success": true,
+		"token":   response,
+	})
+}
+
+func (h *videoHandler) ListVideos(ctx *fiber.Ctx) error {
+	request := &model.ListVideosRequest{
+		Owner:  ctx.Query("owner"),
+		Status: ctx.Query("status"),
+	}
+
+	response, err := h.videoUseCase.ListVideos(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(http.StatusOK).JSON(response)
+}
+
+func (h *videoHandler) GetVideo(ctx *fiber.Ctx) error {
+	request := &model.GetVideoRequest{
+		VideoID: ctx.Params("videoID"),
+	}
+
+	response, err := h.videoUseCase.GetVideo(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(http.StatusOK).JSON(response)
+}
+
+func (h *videoHandler) UpdateVideo(ctx *fiber.Ctx) error {
+	request := new(model.UpdateVideoRequest)
+	if err := ctx.BodyParser(request); err != nil {
+		log.Printf("[CLIENT ERROR] [UPDATE VIDEO] body parser error : %v", err)
+		return fiber.NewError(http.StatusBadRequest, "Invalid request body")
+	}
+	request.VideoID = ctx.Params("videoID")
+
+	response, err := h.videoUseCase.UpdateVideo(ctx.Context(), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(http.StatusOK).JSON(response)
+}
+
+func (h *videoHandler) DeleteVideo(ctx *fiber.Ctx) error {
+	request := &model.DeleteVideoRequest{
+		VideoID: ctx.Params("videoID"),
+	}
+
+	if err := h.videoUseCase.DeleteVideo(ctx.Context(), request); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(http.StatusNoContent)
+}
+
+// playbackToken reads the token from the query string, which is how players
+// send it on key requests, or from an Authorization: Bearer header.
+func playbackToken(ctx *fiber.Ctx) string {
+	if token := ctx.Query("token"); token != "" {
+		return token
+	}
+	return strings.TrimPrefix(ctx.Get(fiber.HeaderAuthorization), "Bearer ")
+}
@@ -12,6 +12,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -25,33 +26,116 @@ func main() {
 		panic(err)
 	}
 
-	minio := util.InitMinio()
+	objectStore := util.InitObjectStore()
+	db := util.InitBolt()
+	defer db.Close()
 
-	videoRepo := repository.NewVideoRepository()
+	if err := repository.Migrate(db, objectStore); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	videoRepo := repository.NewVideoRepository(db)
+	uploadRepo := repository.NewUploadRepository(db)
+	jobRepo := repository.NewJobRepository(db)
+	profileRepo, err := repository.NewProfileRepository(os.Getenv("PROFILES_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to load encoding profiles: %v", err)
+	}
+
+	var keyStore repository.KeyStore
+	switch backend := os.Getenv("KEYSTORE_BACKEND"); backend {
+	case "", "envelope":
+		keyStore = repository.NewEnvelopeKeyStore(db, util.InitMasterKeyring())
+		rotated, err := keyStore.RotateMasterKey(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to rotate master key: %v", err)
+		}
+		if rotated > 0 {
+			log.Printf("Rewrapped %d content keys with the current master key", rotated)
+		}
+	case "file":
+		log.Print("Using the file key store, content keys are stored unwrapped")
+		keyStore = repository.NewFileKeyStore(os.Getenv("KEYSTORE_DIR"))
+	default:
+		log.Fatalf("Unknown KEYSTORE_BACKEND %q", backend)
+	}
 
-	encodeUC := usecase.NewEncodeUseCase(minio)
-	videoUC := usecase.NewVideoUseCase(minio, videoRepo)
+	jobUC := usecase.NewJobUseCase(jobRepo)
+	encodeUC := usecase.NewEncodeUseCase(objectStore, jobUC, profileRepo, videoRepo, keyStore, util.InitEncoders(), util.InitImportConfig())
+	videoUC := usecase.NewVideoUseCase(objectStore, videoRepo, keyStore, util.InitTokenSigner(), util.InitSessionSigner())
+	tusConfig := util.InitTusConfig()
+	uploadUC := usecase.NewUploadUseCase(uploadRepo, encodeUC, jobUC, objectStore, tusConfig)
+	importUC := usecase.NewImportUseCase(encodeUC)
 	encodeWorker := worker.NewEncodeWorker(1, encodeUC)
+	uploadCleaner := worker.NewUploadCleaner(time.Hour, uploadUC)
 
 	ctx, cancel := context.WithCancel(context.Background())
+	if err := encodeUC.RecoverInterrupted(ctx); err != nil {
+		log.Fatal(err)
+	}
 	go func() {
 		encodeWorker.Run(ctx)
 	}()
+	go uploadCleaner.Run(ctx)
 
-	encodeHandler := handler.NewEncodeHandler(encodeUC, encodeWorker)
+	encodeHandler := handler.NewEncodeHandler(encodeUC, importUC, jobUC, encodeWorker)
 	videoHandler := handler.NewVideoHandler(videoUC)
-
-	app := fiber.New()
+	jobHandler := handler.NewJobHandler(jobUC)
+	tusHandler := handler.NewTusHandler(uploadUC, encodeUC, encodeWorker, tusConfig.MaxSize)
+	directUploadHandler := handler.NewDirectUploadHandler(uploadUC, encodeUC, encodeWorker)
+
+	// Request bodies are streamed so that the tus PATCH and the presigned PUT
+	// of the local store can write uploads of any size straight to disk.
+	// Those two routes are registered before LimitBody, every other route
+	// buffers at most the default body limit. Multipart forms are parsed by
+	// the handlers, after LimitBody, instead of while reading the request.
+	app := fiber.New(fiber.Config{
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowOrigins:  "*",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, " + strings.Join(handler.TusRequestHeaders, ", "),
+		ExposeHeaders: strings.Join(handler.TusResponseHeaders, ", "),
 	}))
 
+	app.Patch("/video/uploads/:uploadID", tusHandler.PatchUpload)
+	var storageHandler handler.StorageHandler
+	if localStore, ok := objectStore.(*util.LocalStore); ok {
+		storageHandler = handler.NewStorageHandler(localStore)
+		app.Put(util.LocalStoreRoute+"/*", storageHandler.ReceiveObject)
+	}
+
+	app.Use(handler.LimitBody(fiber.DefaultBodyLimit))
+
+	app.Get("/videos", videoHandler.ListVideos)
+	app.Get("/videos/:videoID", videoHandler.GetVideo)
+	app.Patch("/videos/:videoID", videoHandler.UpdateVideo)
+	app.Delete("/videos/:videoID", videoHandler.DeleteVideo)
 	app.Get("/videos/:videoID/playlists/:playlist", videoHandler.VideoManifest)
+	app.Get("/videos/:videoID/manifests/:name.mpd", videoHandler.VideoDashManifest)
 	app.Get("/videos/:videoID/keys/:key", videoHandler.VideoKey)
+	app.Post("/videos/:videoID/tokens", videoHandler.IssuePlaybackToken)
+	app.Get("/videos/:videoID/thumbnails.vtt", videoHandler.VideoThumbnails)
+	app.Post("/videos/:videoID/subtitles", videoHandler.UploadSubtitle)
+
+	if storageHandler != nil {
+		app.Get(util.LocalStoreRoute+"/*", storageHandler.ServeObject)
+	}
 
 	app.Post("/video/upload", encodeHandler.UploadVideo)
+	app.Post("/video/import", encodeHandler.ImportVideo)
+	app.Options("/video/uploads", tusHandler.Options)
+	app.Post("/video/uploads", tusHandler.CreateUpload)
+	app.Head("/video/uploads/:uploadID", tusHandler.HeadUpload)
+	app.Delete("/video/uploads/:uploadID", tusHandler.DeleteUpload)
+	app.Post("/video/direct-uploads", directUploadHandler.CreateDirectUpload)
+	app.Post("/video/direct-uploads/:uploadID/complete", directUploadHandler.CompleteDirectUpload)
+
+	app.Get("/jobs", jobHandler.ListJobs)
+	app.Get("/jobs/:id", jobHandler.GetJob)
+	app.Get("/jobs/:id/events", jobHandler.JobEvents)
 
 	interuptSignal := make(chan os.Signal, 1)
 	signal.Notify(interuptSignal, os.Interrupt, syscall.SIGTERM)
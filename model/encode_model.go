@@ -1,10 +1,30 @@
 package model
 
 type EncodeRequest struct {
-	OutputDir string `json:"output_dir"`
-	S3Prefix  string `json:"s3_prefix"`
-	APIServer string `json:"api_server"`
-	VideoID   string `json:"video_id"`
-	InputPath string `json:"input_path"`
-	Playlist  string `json:"playlist"`
+	JobID            string `json:"job_id"`
+	OutputDir        string `json:"output_dir"`
+	S3Prefix         string `json:"s3_prefix"`
+	APIServer        string `json:"api_server"`
+	VideoID          string `json:"video_id"`
+	OriginalFilename string `json:"original_filename"`
+	InputPath        string `json:"input_path"`
+	SourceKey        string `json:"source_key,omitempty"` // object the source is downloaded from instead of usecase/tmp
+	SourceURL        string `json:"source_url,omitempty"` // URL the source is imported from instead of usecase/tmp
+	Playlist         string `json:"playlist"`
+	Profile          string `json:"profile"`
+	Title            string `json:"title"`
+	Owner            string `json:"owner"`
+}
+
+type PlaybackURLs struct {
+	HLS        string `json:"hls"`
+	DASH       string `json:"dash,omitempty"`
+	Thumbnails string `json:"thumbnails"`
+}
+
+type UploadVideoResponse struct {
+	VideoID          string        `json:"video_id"`
+	OriginalFilename string        `json:"original_filename"`
+	Playback         *PlaybackURLs `json:"playback"`
+	Job              *JobResponse  `json:"job"`
 }
@@ -0,0 +1,9 @@
+package model
+
+type ImportVideoRequest struct {
+	URL      string `json:"url"`
+	Filename string `json:"filename"` // defaults to the last path segment of URL
+	Profile  string `json:"profile"`
+	Title    string `json:"title"`
+	Owner    string `json:"owner"`
+}
@@ -0,0 +1,54 @@
+package model
+
+import "time"
+
+type GetJobRequest struct {
+	JobID string `json:"job_id"`
+}
+
+type ListJobsRequest struct {
+	Status string `json:"status"`
+}
+
+type JobEventResponse struct {
+	Status  string    `json:"status"`
+	Variant string    `json:"variant,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type JobProbeResponse struct {
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	DisplayWidth  int     `json:"display_width"`
+	DisplayHeight int     `json:"display_height"`
+	Rotation      int     `json:"rotation"`
+	FPS           float64 `json:"fps"`
+	DurationMs    int64   `json:"duration_ms"`
+}
+
+type JobResponse struct {
+	ID        string             `json:"id"`
+	VideoID   string             `json:"video_id"`
+	Profile   string             `json:"profile"`
+	Status    string             `json:"status"`
+	Variant   string             `json:"variant,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Probe     *JobProbeResponse  `json:"probe,omitempty"`
+	Events    []JobEventResponse `json:"events"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// JobProgressEvent is streamed to clients over the job events endpoint.
+type JobProgressEvent struct {
+	JobID          string  `json:"job_id"`
+	Status         string  `json:"status"`
+	Variant        string  `json:"variant,omitempty"`
+	OutTimeMs      int64   `json:"out_time_ms"`
+	FPS            float64 `json:"fps"`
+	Speed          float64 `json:"speed"`
+	VariantPercent float64 `json:"variant_percent"`
+	Percent        float64 `json:"percent"`
+	Error          string  `json:"error,omitempty"`
+}
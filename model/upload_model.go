@@ -0,0 +1,61 @@
+package model
+
+import (
+	"io"
+	"time"
+)
+
+type CreateUploadRequest struct {
+	Length   int64  `json:"length"`
+	Filename string `json:"filename"`
+	Profile  string `json:"profile"`
+	Title    string `json:"title"`
+	Owner    string `json:"owner"`
+}
+
+type UploadRequest struct {
+	UploadID string `json:"upload_id"`
+}
+
+type AppendUploadRequest struct {
+	UploadID      string    `json:"upload_id"`
+	Offset        int64     `json:"offset"`
+	ContentLength int64     `json:"content_length"` // -1 when unknown
+	Body          io.Reader `json:"-"`
+}
+
+type UploadResponse struct {
+	ID        string    `json:"id"`
+	VideoID   string    `json:"video_id"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	Completed bool      `json:"completed"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Encode is set by the request that completes the upload, with the
+	// source moved to where the encoder reads it and its job created.
+	Encode *EncodeRequest `json:"-"`
+}
+
+type CreateDirectUploadRequest struct {
+	Size     int64  `json:"size"`
+	Filename string `json:"filename"`
+	Profile  string `json:"profile"`
+	Title    string `json:"title"`
+	Owner    string `json:"owner"`
+}
+
+type DirectUploadResponse struct {
+	ID        string    `json:"id"`
+	VideoID   string    `json:"video_id"`
+	Size      int64     `json:"size"`
+	Method    string    `json:"method"`
+	UploadURL string    `json:"upload_url,omitempty"`
+	Completed bool      `json:"completed"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Encode and Job are set once the upload is complete, with the source
+	// key the encoder downloads from and the job created for it.
+	Encode *EncodeRequest `json:"-"`
+	Job    *JobResponse   `json:"-"`
+}
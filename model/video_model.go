@@ -1,12 +1,98 @@
 package model
 
+import "time"
+
 type VideoManifestRequest struct {
 	VideoID  string `json:"video_id"`
 	Playlist string `json:"playlist"`
+	Token    string `json:"token"`
+	ClientIP string `json:"-"`
+}
+
+type VideoDashManifestRequest struct {
+	VideoID string `json:"video_id"`
+	Name    string `json:"name"`
+}
+
+type VideoThumbnailsRequest struct {
+	VideoID string `json:"video_id"`
 }
 
 type VideoKeyRequest struct {
 	VideoID  string `json:"video_id"`
 	Playlist string `json:"playlist"`
 	KeyName  string `json:"key_name"`
+	Token    string `json:"token"`
+	ClientIP string `json:"-"`
+}
+
+type PlaybackTokenRequest struct {
+	VideoID  string `json:"video_id"`
+	BindIP   bool   `json:"bind_ip"`
+	Session  string `json:"-"` // CMS session token of the caller
+	ClientIP string `json:"-"`
+}
+
+type PlaybackTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type SubtitleUploadRequest struct {
+	VideoID  string `json:"video_id"`
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	Default  bool   `json:"default"`
+	Data     []byte `json:"-"`
+}
+
+type SubtitleResponse struct {
+	VideoID  string `json:"video_id"`
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	Playlist string `json:"playlist"`
+	Segments int    `json:"segments"`
+}
+
+type ListVideosRequest struct {
+	Owner  string `json:"owner"`
+	Status string `json:"status"`
+}
+
+type GetVideoRequest struct {
+	VideoID string `json:"video_id"`
+}
+
+// UpdateVideoRequest changes only the fields that are present.
+type UpdateVideoRequest struct {
+	VideoID     string    `json:"-"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+}
+
+type DeleteVideoRequest struct {
+	VideoID string `json:"video_id"`
+}
+
+type VideoRenditionResponse struct {
+	Label     string `json:"label"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Bandwidth int    `json:"bandwidth"`
+	Codecs    string `json:"codecs"`
+}
+
+type VideoResponse struct {
+	ID               string                   `json:"id"`
+	Title            string                   `json:"title"`
+	Description      string                   `json:"description"`
+	Tags             []string                 `json:"tags"`
+	Owner            string                   `json:"owner,omitempty"`
+	OriginalFilename string                   `json:"original_filename,omitempty"`
+	Status           string                   `json:"status"`
+	DurationMs       int64                    `json:"duration_ms"`
+	Renditions       []VideoRenditionResponse `json:"renditions"`
+	CreatedAt        time.Time                `json:"created_at"`
+	UpdatedAt        time.Time                `json:"updated_at"`
 }
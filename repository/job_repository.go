@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ffmpeg-hls/entity"
+	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+var jobBucket = []byte("jobs")
+
+type JobRepository interface {
+	Save(ctx context.Context, job *entity.Job) error
+	GetByID(ctx context.Context, id string) (*entity.Job, error)
+	List(ctx context.Context, status string) ([]*entity.Job, error)
+}
+
+type jobRepository struct {
+	db *bolt.DB
+}
+
+func NewJobRepository(db *bolt.DB) JobRepository {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobBucket)
+		return err
+	}); err != nil {
+		panic(fmt.Errorf("create jobs bucket: %w", err))
+	}
+
+	return &jobRepository{db: db}
+}
+
+func (r *jobRepository) Save(ctx context.Context, job *entity.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal job: %w", err)
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (r *jobRepository) GetByID(ctx context.Context, id string) (*entity.Job, error) {
+	var job *entity.Job
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobBucket).Get([]byte(id))
+		if data == nil {
+			return ErrJobNotFound
+		}
+
+		job = new(entity.Job)
+		return json.Unmarshal(data, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// List returns all jobs, newest first. An empty status matches every job.
+func (r *jobRepository) List(ctx context.Context, status string) ([]*entity.Job, error) {
+	jobs := make([]*entity.Job, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobBucket).ForEach(func(_, data []byte) error {
+			job := new(entity.Job)
+			if err := json.Unmarshal(data, job); err != nil {
+				return err
+			}
+			if status == "" || job.Status == status {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	return jobs, nil
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/util"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrKeyNotFound = errors.New("content key not found")
+
+var contentKeyBucket = []byte("content_keys")
+
+// KeyStore keeps the AES-128 content keys of encrypted renditions, addressed
+// by video ID and key file name.
+type KeyStore interface {
+	Put(ctx context.Context, videoID, name string, key []byte) error
+	Get(ctx context.Context, videoID, name string) ([]byte, error)
+	// Delete removes every key of a video.
+	Delete(ctx context.Context, videoID string) error
+	// RotateMasterKey rewraps every key still wrapped with a previous master
+	// key and returns how many were rewrapped.
+	RotateMasterKey(ctx context.Context) (int, error)
+}
+
+type envelopeKeyStore struct {
+	db      *bolt.DB
+	keyring *util.MasterKeyring
+}
+
+// NewEnvelopeKeyStore stores content keys in the database, each wrapped with
+// the current master key of keyring.
+func NewEnvelopeKeyStore(db *bolt.DB, keyring *util.MasterKeyring) KeyStore {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(contentKeyBucket)
+		return err
+	}); err != nil {
+		panic(fmt.Errorf("create content keys bucket: %w", err))
+	}
+
+	return &envelopeKeyStore{db: db, keyring: keyring}
+}
+
+func contentKeyID(videoID, name string) []byte {
+	return []byte(videoID + "/" + name)
+}
+
+func (s *envelopeKeyStore) Put(ctx context.Context, videoID, name string, key []byte) error {
+	record := &entity.ContentKey{
+		VideoID:   videoID,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.wrap(record, key); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.save(tx, record)
+	})
+}
+
+func (s *envelopeKeyStore) Get(ctx context.Context, videoID, name string) ([]byte, error) {
+	var record *entity.ContentKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(contentKeyBucket).Get(contentKeyID(videoID, name))
+		if data == nil {
+			return ErrKeyNotFound
+		}
+
+		record = new(entity.ContentKey)
+		return json.Unmarshal(data, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.keyring.Unwrap(record.MasterKeyID, record.Nonce, record.Ciphertext, contentKeyID(videoID, name))
+}
+
+func (s *envelopeKeyStore) Delete(ctx context.Context, videoID string) error {
+	prefix := contentKeyID(videoID, "")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(contentKeyBucket).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Seek(prefix) {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *envelopeKeyStore) RotateMasterKey(ctx context.Context) (int, error) {
+	rotated := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var stale []*entity.ContentKey
+		if err := tx.Bucket(contentKeyBucket).ForEach(func(_, data []byte) error {
+			record := new(entity.ContentKey)
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			if record.MasterKeyID != s.keyring.CurrentID() {
+				stale = append(stale, record)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, record := range stale {
+			key, err := s.keyring.Unwrap(record.MasterKeyID, record.Nonce, record.Ciphertext, contentKeyID(record.VideoID, record.Name))
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", record.VideoID, record.Name, err)
+			}
+			if err := s.wrap(record, key); err != nil {
+				return err
+			}
+			if err := s.save(tx, record); err != nil {
+				return err
+			}
+			rotated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return rotated, nil
+}
+
+func (s *envelopeKeyStore) wrap(record *entity.ContentKey, key []byte) error {
+	keyID, nonce, ciphertext, err := s.keyring.Wrap(key, contentKeyID(record.VideoID, record.Name))
+	if err != nil {
+		return err
+	}
+
+	record.MasterKeyID = keyID
+	record.Nonce = nonce
+	record.Ciphertext = ciphertext
+	return nil
+}
+
+func (s *envelopeKeyStore) save(tx *bolt.Tx, record *entity.ContentKey) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal content key: %w", err)
+	}
+
+	return tx.Bucket(contentKeyBucket).Put(contentKeyID(record.VideoID, record.Name), data)
+}
+
+type fileKeyStore struct {
+	dir string
+}
+
+// NewFileKeyStore keeps unwrapped content keys as files under dir, for local
+// development only.
+func NewFileKeyStore(dir string) KeyStore {
+	if dir == "" {
+		dir = filepath.Join("data", "keys")
+	}
+	return &fileKeyStore{dir: dir}
+}
+
+func (s *fileKeyStore) path(videoID, name string) (string, error) {
+	for _, part := range []string{videoID, name} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return "", fmt.Errorf("invalid key path %q/%q", videoID, name)
+		}
+	}
+	return filepath.Join(s.dir, videoID, name), nil
+}
+
+func (s *fileKeyStore) Put(ctx context.Context, videoID, name string, key []byte) error {
+	path, err := s.path(videoID, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create key dir: %w", err)
+	}
+
+	return os.WriteFile(path, key, 0600)
+}
+
+func (s *fileKeyStore) Get(ctx context.Context, videoID, name string) ([]byte, error) {
+	path, err := s.path(videoID, name)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	return key, err
+}
+
+func (s *fileKeyStore) Delete(ctx context.Context, videoID string) error {
+	path, err := s.path(videoID, "_")
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Dir(path))
+}
+
+func (s *fileKeyStore) RotateMasterKey(ctx context.Context) (int, error) {
+	return 0, nil
+}
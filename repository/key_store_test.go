@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ffmpeg-hls/util"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestEnvelopeKeyStoreRotation(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "keys.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	oldMaster := bytes.Repeat([]byte{1}, 32)
+	newMaster := bytes.Repeat([]byte{2}, 32)
+	content := bytes.Repeat([]byte{7}, 16)
+	ctx := context.Background()
+
+	oldRing, err := util.NewMasterKeyring(oldMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewEnvelopeKeyStore(db, oldRing)
+	if err := store.Put(ctx, "vid", "enc_360p.key", content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if bytes.Contains(tx.Bucket(contentKeyBucket).Get(contentKeyID("vid", "enc_360p.key")), content) {
+			t.Error("content key stored unwrapped")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	newOnly, _ := util.NewMasterKeyring(newMaster)
+	if _, err := NewEnvelopeKeyStore(db, newOnly).Get(ctx, "vid", "enc_360p.key"); !errors.Is(err, util.ErrUnknownMasterKey) {
+		t.Fatalf("expected unknown master key, got %v", err)
+	}
+
+	rotating, _ := util.NewMasterKeyring(newMaster, oldMaster)
+	store = NewEnvelopeKeyStore(db, rotating)
+	rotated, err := store.RotateMasterKey(ctx)
+	if err != nil || rotated != 1 {
+		t.Fatalf("expected one rewrapped key, got %d (%v)", rotated, err)
+	}
+	if rotated, _ := store.RotateMasterKey(ctx); rotated != 0 {
+		t.Fatalf("second rotation rewrapped %d keys", rotated)
+	}
+
+	key, err := NewEnvelopeKeyStore(db, newOnly).Get(ctx, "vid", "enc_360p.key")
+	if err != nil || !bytes.Equal(key, content) {
+		t.Fatalf("key not readable with the new master key alone: %v", err)
+	}
+
+	if _, err := store.Get(ctx, "vid", "enc_720p.key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	for _, id := range []string{"vid2", "vid"} {
+		if err := store.Put(ctx, id, "enc_720p.key", content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "vid"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"enc_360p.key", "enc_720p.key"} {
+		if _, err := store.Get(ctx, "vid", name); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("%s not deleted: %v", name, err)
+		}
+	}
+	if _, err := store.Get(ctx, "vid2", "enc_720p.key"); err != nil {
+		t.Fatalf("key of another video deleted: %v", err)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	store := NewFileKeyStore(t.TempDir())
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "vid", "enc_360p.key", []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := store.Get(ctx, "vid", "enc_360p.key"); err != nil || string(key) != "0123456789abcdef" {
+		t.Fatalf("unexpected key %q (%v)", key, err)
+	}
+
+	if _, err := store.Get(ctx, "vid", "enc_720p.key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := store.Delete(ctx, "vid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "vid", "enc_360p.key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("key not deleted: %v", err)
+	}
+	if err := store.Delete(ctx, ".."); err == nil {
+		t.Fatal("expected path traversal to be rejected on delete")
+	}
+
+	if err := store.Put(ctx, "..", "enc_360p.key", nil); err == nil {
+		t.Fatal("expected path traversal to be rejected")
+	}
+}
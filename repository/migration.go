@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/util"
+	"fmt"
+	"log"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metaBucket    = []byte("meta")
+	schemaVersion = []byte("schema_version")
+)
+
+// migration is one step of the database schema. Steps run in order inside a
+// single transaction each, and the schema version is stored with them so a
+// step never runs twice. Steps that backfill data from storage get the
+// object store.
+type migration struct {
+	description string
+	apply       func(tx *bolt.Tx, store util.ObjectStore) error
+}
+
+// migrations must only ever be appended to.
+var migrations = []migration{
+	{
+		description: "create videos bucket",
+		apply: func(tx *bolt.Tx, _ util.ObjectStore) error {
+			_, err := tx.CreateBucketIfNotExists(videoBucket)
+			return err
+		},
+	},
+	{
+		description: "create uploads bucket",
+		apply: func(tx *bolt.Tx, _ util.ObjectStore) error {
+			_, err := tx.CreateBucketIfNotExists(uploadBucket)
+			return err
+		},
+	},
+	{
+		description: "backfill videos encoded before the videos bucket",
+		apply:       backfillLegacyVideos,
+	},
+}
+
+// legacyVideoPrefix is where encodes stored videos before they had records,
+// one courses/<id>/ folder with a master.m3u8 per video.
+const legacyVideoPrefix = "courses/"
+
+// backfillLegacyVideos creates a ready record for every legacy video folder
+// that has none, so older videos keep playing by the ID in their folder name.
+func backfillLegacyVideos(tx *bolt.Tx, store util.ObjectStore) error {
+	objects, err := store.ListObjects(context.Background(), legacyVideoPrefix)
+	if err != nil {
+		return fmt.Errorf("list legacy videos: %w", err)
+	}
+
+	bucket := tx.Bucket(videoBucket)
+	for _, object := range objects {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(object.Key, legacyVideoPrefix), "/master.m3u8")
+		if !ok || id == "" || strings.Contains(id, "/") || bucket.Get([]byte(id)) != nil {
+			continue
+		}
+
+		data, err := json.Marshal(&entity.Video{
+			ID:        id,
+			Title:     id,
+			Dir:       legacyVideoPrefix + id,
+			Status:    entity.VideoStatusReady,
+			CreatedAt: object.LastModified,
+			UpdatedAt: object.LastModified,
+		})
+		if err != nil {
+			return fmt.Errorf("marshal video: %w", err)
+		}
+		if err := bucket.Put([]byte(id), data); err != nil {
+			return err
+		}
+		log.Printf("Backfilled legacy video %s", id)
+	}
+
+	return nil
+}
+
+// Migrate brings the database schema up to date, reading the videos stored
+// before the schema existed from store.
+func Migrate(db *bolt.DB, store util.ObjectStore) error {
+	for {
+		applied, err := applyNextMigration(db, store)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return nil
+		}
+	}
+}
+
+func applyNextMigration(db *bolt.DB, store util.ObjectStore) (bool, error) {
+	applied := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+
+		var version uint64
+		if data := meta.Get(schemaVersion); data != nil {
+			version = binary.BigEndian.Uint64(data)
+		}
+		if version > uint64(len(migrations)) {
+			return fmt.Errorf("database schema version %d is newer than this build (%d)", version, len(migrations))
+		}
+		if version == uint64(len(migrations)) {
+			return nil
+		}
+
+		step := migrations[version]
+		if err := step.apply(tx, store); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", version+1, step.description, err)
+		}
+
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, version+1)
+		if err := meta.Put(schemaVersion, data); err != nil {
+			return err
+		}
+
+		log.Printf("Applied migration %d: %s", version+1, step.description)
+		applied = true
+		return nil
+	})
+
+	return applied, err
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"ffmpeg-hls/entity"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultProfileName = "default"
+
+var ErrProfileNotFound = errors.New("profile not found")
+
+type ProfileRepository interface {
+	GetByName(name string) (*entity.EncodingProfile, error)
+	List() []*entity.EncodingProfile
+}
+
+type profileRepository struct {
+	defaultName string
+	profiles    map[string]*entity.EncodingProfile
+}
+
+type profileConfig struct {
+	Default  string                             `json:"default" yaml:"default"`
+	Profiles map[string]*entity.EncodingProfile `json:"profiles" yaml:"profiles"`
+}
+
+// NewProfileRepository loads encoding profiles from a YAML or JSON file. An
+// empty path falls back to the built-in default profile.
+func NewProfileRepository(path string) (ProfileRepository, error) {
+	config := &profileConfig{
+		Default:  DefaultProfileName,
+		Profiles: map[string]*entity.EncodingProfile{DefaultProfileName: builtinProfile()},
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read profiles: %w", err)
+		}
+
+		config = new(profileConfig)
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			err = json.Unmarshal(data, config)
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, config)
+		default:
+			err = fmt.Errorf("unsupported profiles format %q", filepath.Ext(path))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode profiles: %w", err)
+		}
+	}
+
+	if len(config.Profiles) == 0 {
+		return nil, fmt.Errorf("no encoding profiles defined")
+	}
+	if config.Default == "" {
+		config.Default = DefaultProfileName
+	}
+	if _, ok := config.Profiles[config.Default]; !ok {
+		return nil, fmt.Errorf("default profile %q is not defined", config.Default)
+	}
+
+	for name, profile := range config.Profiles {
+		if profile == nil {
+			return nil, fmt.Errorf("profile %q is empty", name)
+		}
+		profile.Name = name
+		if err := normalizeProfile(profile); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+	}
+
+	return &profileRepository{
+		defaultName: config.Default,
+		profiles:    config.Profiles,
+	}, nil
+}
+
+// GetByName returns the named profile, or the default one for an empty name.
+func (r *profileRepository) GetByName(name string) (*entity.EncodingProfile, error) {
+	if name == "" {
+		name = r.defaultName
+	}
+
+	profile, ok := r.profiles[name]
+	if !ok {
+		return nil, ErrProfileNotFound
+	}
+
+	return profile, nil
+}
+
+func (r *profileRepository) List() []*entity.EncodingProfile {
+	profiles := make([]*entity.EncodingProfile, 0, len(r.profiles))
+	for _, profile := range r.profiles {
+		profiles = append(profiles, profile)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles
+}
+
+func builtinProfile() *entity.EncodingProfile {
+	return &entity.EncodingProfile{
+		Codec:           "libx264",
+		SegmentDuration: 4,
+		SegmentFormat:   entity.SegmentFormatTS,
+		Encryption:      true,
+		Ladder: []entity.LadderRung{
+			{Label: "360p", Size: 360, Bitrate: "500k"},
+			{Label: "480p", Size: 480, Bitrate: "1000k"},
+			{Label: "720p", Size: 720, Bitrate: "2000k"},
+			{Label: "1080p", Size: 1080, Bitrate: "4000k"},
+		},
+	}
+}
+
+// normalizeProfile validates a profile, fills defaults and sorts its ladder
+// from the lowest to the highest rung.
+func normalizeProfile(profile *entity.EncodingProfile) error {
+	if profile.Codec == "" {
+		profile.Codec = "libx264"
+	}
+	if entity.CodecFamily(profile.Codec) == "" {
+		return fmt.Errorf("unsupported codec %q", profile.Codec)
+	}
+	if profile.SegmentDuration == 0 {
+		profile.SegmentDuration = 4
+	}
+	if profile.SegmentDuration < 0 {
+		return fmt.Errorf("segment_duration must be positive")
+	}
+	switch profile.SegmentFormat {
+	case "":
+		profile.SegmentFormat = entity.SegmentFormatTS
+	case entity.SegmentFormatTS, entity.SegmentFormatFMP4:
+	default:
+		return fmt.Errorf("segment_format must be %q or %q", entity.SegmentFormatTS, entity.SegmentFormatFMP4)
+	}
+	if profile.DASH {
+		// The MPD reuses the HLS segments, which must be CMAF and cannot use
+		// HLS whole-segment AES-128 encryption. The MPD only lists audio in
+		// its own AdaptationSet, so audio must not be muxed into the video.
+		if profile.SegmentFormat != entity.SegmentFormatFMP4 {
+			return fmt.Errorf("dash requires segment_format %q", entity.SegmentFormatFMP4)
+		}
+		if !profile.SeparateAudio {
+			return fmt.Errorf("dash requires separate_audio")
+		}
+		if profile.Encryption {
+			return fmt.Errorf("dash cannot be combined with AES-128 encryption")
+		}
+	}
+	if profile.KeyRotation < 0 {
+		return fmt.Errorf("key_rotation_segments must not be negative")
+	}
+	if profile.KeyRotation > 0 && !profile.Encryption {
+		return fmt.Errorf("key_rotation_segments requires encryption")
+	}
+	if profile.IFrames && profile.Encryption {
+		// I-frame playlists address byte ranges inside segments, which cannot
+		// be decrypted on their own under whole-segment AES-128.
+		return fmt.Errorf("iframe_playlists cannot be combined with AES-128 encryption")
+	}
+	if profile.GOPSeconds < 0 {
+		return fmt.Errorf("gop_seconds must not be negative")
+	}
+	if profile.GOPSeconds > 0 {
+		// Segment boundaries must land on a GOP boundary to stay aligned.
+		gops := float64(profile.SegmentDuration) / profile.GOPSeconds
+		if math.Abs(gops-math.Round(gops)) > 1e-9 {
+			return fmt.Errorf("segment_duration %d must be a multiple of gop_seconds %g", profile.SegmentDuration, profile.GOPSeconds)
+		}
+	}
+
+	if profile.AudioBitrate != "" {
+		if _, err := ParseBitrate(profile.AudioBitrate); err != nil {
+			return fmt.Errorf("audio_bitrate: %w", err)
+		}
+	}
+
+	if len(profile.Ladder) == 0 {
+		return fmt.Errorf("ladder must have at least one rung")
+	}
+
+	labels := make(map[string]struct{}, len(profile.Ladder))
+	for i := range profile.Ladder {
+		rung := &profile.Ladder[i]
+		if rung.Label == "" {
+			return fmt.Errorf("ladder rung %d has no label", i)
+		}
+		if strings.ContainsAny(rung.Label, "/\\ ") {
+			return fmt.Errorf("ladder label %q must not contain slashes or spaces", rung.Label)
+		}
+		if _, ok := labels[rung.Label]; ok {
+			return fmt.Errorf("duplicate ladder label %q", rung.Label)
+		}
+		labels[rung.Label] = struct{}{}
+
+		if rung.Size <= 0 {
+			return fmt.Errorf("ladder rung %q must have a positive size", rung.Label)
+		}
+
+		if _, err := ParseBitrate(rung.Bitrate); err != nil {
+			return fmt.Errorf("ladder rung %q bitrate: %w", rung.Label, err)
+		}
+
+		if rung.Codec == "" {
+			rung.Codec = profile.Codec
+		}
+		family := entity.CodecFamily(rung.Codec)
+		if family == "" {
+			return fmt.Errorf("ladder rung %q has unsupported codec %q", rung.Label, rung.Codec)
+		}
+		// HEVC and AV1 are only playable from fMP4 segments in HLS.
+		if family != entity.CodecFamilyH264 && profile.SegmentFormat != entity.SegmentFormatFMP4 {
+			return fmt.Errorf("ladder rung %q uses %s which requires segment_format %q", rung.Label, family, entity.SegmentFormatFMP4)
+		}
+	}
+
+	sort.SliceStable(profile.Ladder, func(i, j int) bool {
+		return profile.Ladder[i].Size < profile.Ladder[j].Size
+	})
+
+	return nil
+}
+
+// ParseBitrate converts an ffmpeg style bitrate such as "800k" or "4M" to bits
+// per second.
+func ParseBitrate(raw string) (int, error) {
+	value := strings.TrimSpace(raw)
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "k"), strings.HasSuffix(value, "K"):
+		multiplier = 1000
+	case strings.HasSuffix(value, "m"), strings.HasSuffix(value, "M"):
+		multiplier = 1000000
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", raw)
+	}
+
+	return int(number * float64(multiplier)), nil
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeProfiles(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewProfileRepositoryExample(t *testing.T) {
+	repo, err := NewProfileRepository(filepath.Join("..", "profiles.example.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := repo.GetByName("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "talking-head" || len(profile.Ladder) != 4 {
+		t.Fatalf("unexpected default profile: %+v", profile)
+	}
+
+	if _, err := repo.GetByName("missing"); err != ErrProfileNotFound {
+		t.Fatalf("expected ErrProfileNotFound, got %v", err)
+	}
+}
+
+func TestNewProfileRepositoryJSON(t *testing.T) {
+	path := writeProfiles(t, "profiles.json", `{
+		"default": "lean",
+		"profiles": {
+			"lean": {"ladder": [{"label": "720p", "size": 720, "bitrate": "1M"}, {"label": "360p", "size": 360, "bitrate": "400k"}]}
+		}
+	}`)
+
+	repo, err := NewProfileRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := repo.GetByName("lean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Codec != "libx264" || profile.SegmentDuration != 4 || profile.SegmentFormat != "ts" {
+		t.Fatalf("defaults not applied: %+v", profile)
+	}
+	if profile.Ladder[0].Label != "360p" {
+		t.Fatalf("ladder not sorted: %+v", profile.Ladder)
+	}
+}
+
+func TestNewProfileRepositoryInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing default":             "default: nope\nprofiles:\n  a:\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"empty ladder":                "profiles:\n  default:\n    codec: libx264\n",
+		"bad bitrate":                 "profiles:\n  default:\n    ladder: [{label: 360p, size: 360, bitrate: fast}]\n",
+		"misaligned gop":              "profiles:\n  default:\n    gop_seconds: 3\n    segment_duration: 4\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"bad format":                  "profiles:\n  default:\n    segment_format: webm\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"dash with ts":                "profiles:\n  default:\n    dash: true\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"dash muxed audio":            "profiles:\n  default:\n    dash: true\n    segment_format: fmp4\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"dash encrypted":              "profiles:\n  default:\n    dash: true\n    segment_format: fmp4\n    separate_audio: true\n    encryption: true\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"iframes encrypted":           "profiles:\n  default:\n    iframe_playlists: true\n    encryption: true\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"rotation without encryption": "profiles:\n  default:\n    key_rotation_segments: 10\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"unknown codec":               "profiles:\n  default:\n    codec: mpeg2video\n    ladder: [{label: 360p, size: 360, bitrate: 500k}]\n",
+		"hevc in ts":                  "profiles:\n  default:\n    ladder: [{label: 360p, size: 360, bitrate: 500k, codec: libx265}]\n",
+		"duplicate label":             "profiles:\n  default:\n    ladder: [{label: 360p, size: 360, bitrate: 500k}, {label: 360p, size: 480, bitrate: 1M}]\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewProfileRepository(writeProfiles(t, "profiles.yaml", content)); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ffmpeg-hls/entity"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+var uploadBucket = []byte("uploads")
+
+type UploadRepository interface {
+	Save(ctx context.Context, upload *entity.Upload) error
+	GetByID(ctx context.Context, id string) (*entity.Upload, error)
+	List(ctx context.Context) ([]*entity.Upload, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type uploadRepository struct {
+	db *bolt.DB
+}
+
+// NewUploadRepository expects a database migrated with Migrate.
+func NewUploadRepository(db *bolt.DB) UploadRepository {
+	return &uploadRepository{db: db}
+}
+
+func (r *uploadRepository) Save(ctx context.Context, upload *entity.Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("marshal upload: %w", err)
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadBucket).Put([]byte(upload.ID), data)
+	})
+}
+
+func (r *uploadRepository) GetByID(ctx context.Context, id string) (*entity.Upload, error) {
+	var upload *entity.Upload
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(uploadBucket).Get([]byte(id))
+		if data == nil {
+			return ErrUploadNotFound
+		}
+
+		upload = new(entity.Upload)
+		return json.Unmarshal(data, upload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+func (r *uploadRepository) List(ctx context.Context) ([]*entity.Upload, error) {
+	uploads := make([]*entity.Upload, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadBucket).ForEach(func(_, data []byte) error {
+			upload := new(entity.Upload)
+			if err := json.Unmarshal(data, upload); err != nil {
+				return err
+			}
+			uploads = append(uploads, upload)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+func (r *uploadRepository) Delete(ctx context.Context, id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadBucket).Delete([]byte(id))
+	})
+}
@@ -2,20 +2,128 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"ffmpeg-hls/entity"
 	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
 )
 
+var ErrVideoNotFound = errors.New("video not found")
+
+var videoBucket = []byte("videos")
+
 type VideoRepository interface {
+	Save(ctx context.Context, video *entity.Video) error
 	GetByID(ctx context.Context, id string) (*entity.Video, error)
+	Update(ctx context.Context, id string, update func(video *entity.Video) error) (*entity.Video, error)
+	List(ctx context.Context) ([]*entity.Video, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type videoRepository struct {
+	db *bolt.DB
 }
-type videoRepository struct{}
 
-func NewVideoRepository() VideoRepository {
-	return &videoRepository{}
+// NewVideoRepository expects a database migrated with Migrate.
+func NewVideoRepository(db *bolt.DB) VideoRepository {
+	return &videoRepository{db: db}
+}
+
+func (r *videoRepository) Save(ctx context.Context, video *entity.Video) error {
+	data, err := json.Marshal(video)
+	if err != nil {
+		return fmt.Errorf("marshal video: %w", err)
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(videoBucket).Put([]byte(video.ID), data)
+	})
 }
 
 func (r *videoRepository) GetByID(ctx context.Context, id string) (*entity.Video, error) {
-	return &entity.Video{Dir: fmt.Sprintf("courses/%s", id)}, nil
+	var video *entity.Video
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(videoBucket).Get([]byte(id))
+		if data == nil {
+			return ErrVideoNotFound
+		}
+
+		video = new(entity.Video)
+		return json.Unmarshal(data, video)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return video, nil
+}
+
+// Update applies update to the stored video and saves the result in the same
+// transaction, so concurrent updates of different fields never overwrite
+// each other.
+func (r *videoRepository) Update(ctx context.Context, id string, update func(video *entity.Video) error) (*entity.Video, error) {
+	var video *entity.Video
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(videoBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrVideoNotFound
+		}
+
+		video = new(entity.Video)
+		if err := json.Unmarshal(data, video); err != nil {
+			return err
+		}
+		if err := update(video); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(video)
+		if err != nil {
+			return fmt.Errorf("marshal video: %w", err)
+		}
+		return bucket.Put([]byte(id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return video, nil
+}
+
+// List returns all videos, newest first.
+func (r *videoRepository) List(ctx context.Context) ([]*entity.Video, error) {
+	videos := make([]*entity.Video, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(videoBucket).ForEach(func(_, data []byte) error {
+			video := new(entity.Video)
+			if err := json.Unmarshal(data, video); err != nil {
+				return err
+			}
+			videos = append(videos, video)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.After(videos[j].CreatedAt)
+	})
+
+	return videos, nil
+}
 
+func (r *videoRepository) Delete(ctx context.Context, id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(videoBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrVideoNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
 }
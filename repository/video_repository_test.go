@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/util"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openMigratedDB(t *testing.T) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := Migrate(db, util.NewLocalStore(t.TempDir(), "", nil)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	db := openMigratedDB(t)
+
+	store := util.NewLocalStore(t.TempDir(), "", nil)
+	if err := Migrate(db, store); err != nil {
+		t.Fatalf("migrating twice failed: %v", err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(len(migrations)+1))
+		return tx.Bucket(metaBucket).Put(schemaVersion, data)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db, store); err == nil {
+		t.Fatal("expected a newer schema to be rejected")
+	}
+}
+
+func TestMigrateBackfillsLegacyVideos(t *testing.T) {
+	ctx := context.Background()
+	store := util.NewLocalStore(t.TempDir(), "", nil)
+	for _, key := range []string{
+		"courses/lecture-1/master.m3u8",
+		"courses/lecture-1/360p.m3u8",
+		"courses/lecture-2/master.m3u8",
+		"courses/nested/dir/master.m3u8",
+		"courses/no-master/360p.m3u8",
+	} {
+		if err := store.PutObject(ctx, key, strings.NewReader("#EXTM3U\n"), -1, util.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A video recorded by a newer encode is left as it is.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, step := range migrations[:2] {
+			if err := step.apply(tx, store); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewVideoRepository(db)
+	if err := repo.Save(ctx, &entity.Video{ID: "lecture-2", Title: "Kept", Status: entity.VideoStatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, store); err != nil {
+		t.Fatal(err)
+	}
+
+	videos, err := repo.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 2 {
+		t.Fatalf("got %d videos, want 2: %+v", len(videos), videos)
+	}
+
+	legacy, err := repo.GetByID(ctx, "lecture-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Dir != "courses/lecture-1" || legacy.Status != entity.VideoStatusReady || legacy.Title != "lecture-1" || legacy.CreatedAt.IsZero() {
+		t.Fatalf("unexpected legacy video: %+v", legacy)
+	}
+
+	kept, err := repo.GetByID(ctx, "lecture-2")
+	if err != nil || kept.Title != "Kept" {
+		t.Fatalf("got %+v, %v; want the existing record kept", kept, err)
+	}
+}
+
+func TestVideoRepository(t *testing.T) {
+	repo := NewVideoRepository(openMigratedDB(t))
+	ctx := context.Background()
+
+	if _, err := repo.GetByID(ctx, "missing"); !errors.Is(err, ErrVideoNotFound) {
+		t.Fatalf("expected ErrVideoNotFound, got %v", err)
+	}
+	if _, err := repo.Update(ctx, "missing", func(*entity.Video) error { return nil }); !errors.Is(err, ErrVideoNotFound) {
+		t.Fatalf("expected ErrVideoNotFound on update, got %v", err)
+	}
+
+	now := time.Now()
+	for i, id := range []string{"older", "newer"} {
+		video := &entity.Video{ID: id, Title: id, Dir: "courses/" + id, Status: entity.VideoStatusProcessing, CreatedAt: now.Add(time.Duration(i) * time.Minute)}
+		if err := repo.Save(ctx, video); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated, err := repo.Update(ctx, "older", func(video *entity.Video) error {
+		video.Status = entity.VideoStatusReady
+		video.Renditions = []entity.VideoRendition{{Label: "360p", Width: 640, Height: 360, Bandwidth: 800000}}
+		return nil
+	})
+	if err != nil || updated.Status != entity.VideoStatusReady {
+		t.Fatalf("unexpected update result %+v (%v)", updated, err)
+	}
+
+	video, err := repo.GetByID(ctx, "older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != entity.VideoStatusReady || len(video.Renditions) != 1 || video.Dir != "courses/older" {
+		t.Fatalf("update not persisted: %+v", video)
+	}
+
+	failed := errors.New("rejected")
+	if _, err := repo.Update(ctx, "older", func(video *entity.Video) error {
+		video.Title = "changed"
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Fatalf("expected the update error, got %v", err)
+	}
+	if video, _ := repo.GetByID(ctx, "older"); video.Title != "older" {
+		t.Fatalf("failed update was saved: %+v", video)
+	}
+
+	videos, err := repo.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 2 || videos[0].ID != "newer" || videos[1].ID != "older" {
+		t.Fatalf("unexpected order: %+v", videos)
+	}
+}
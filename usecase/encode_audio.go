@@ -0,0 +1,124 @@
+package usecase
+
+import (
+	"context"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// audioGroupID is the GROUP-ID shared by every audio rendition in the master
+// playlist.
+const audioGroupID = "audio"
+
+// audioRendition is one source audio track encoded into its own playlist.
+type audioRendition struct {
+	Label    string
+	Name     string
+	Language string
+	Channels int
+	Default  bool
+	Stream   int // index among the source audio streams, as in -map 0:a:N
+
+	Segments         []util.MediaSegment
+	PeakBandwidth    int
+	AverageBandwidth int
+	Codecs           string
+}
+
+// buildAudioRenditions returns one rendition per source audio stream. The
+// stream flagged as default by the container is the default rendition, the
+// first stream otherwise.
+func buildAudioRenditions(info *util.MediaInfo) []audioRendition {
+	audio := make([]audioRendition, 0, len(info.AudioStreams))
+	hasDefault := false
+	for i, stream := range info.AudioStreams {
+		// Titles and languages come from the source file and end up in
+		// quoted playlist attributes, so they are sanitized like subtitle
+		// names and languages.
+		language := stream.Language
+		if !subtitleLanguage.MatchString(language) {
+			language = ""
+		}
+		name := playlistName(stream.Title)
+		if name == "" {
+			name = language
+		}
+		if name == "" {
+			name = fmt.Sprintf("Audio %d", i+1)
+		}
+
+		isDefault := stream.Default && !hasDefault
+		hasDefault = hasDefault || isDefault
+
+		audio = append(audio, audioRendition{
+			Label:    fmt.Sprintf("audio_%d", i),
+			Name:     name,
+			Language: language,
+			Channels: stream.Channels,
+			Default:  isDefault,
+			Stream:   i,
+		})
+	}
+
+	if !hasDefault && len(audio) > 0 {
+		audio[0].Default = true
+	}
+
+	return audio
+}
+
+// playlistName makes s safe for a quoted attribute of a playlist: quotes and
+// commas are dropped and line breaks and other control characters become
+// spaces.
+func playlistName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '"' || r == ',':
+			return -1
+		case unicode.IsControl(r):
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func (u *encodeUseCase) encodeAudio(ctx context.Context, req *model.EncodeRequest, profile *entity.EncodingProfile, track audioRendition, onProgress func(util.FFmpegProgress)) error {
+	playlist := filepath.Join(req.OutputDir, fmt.Sprintf("%s.m3u8", track.Label))
+
+	args := []string{
+		"-i", req.InputPath,
+		"-map", fmt.Sprintf("0:a:%d", track.Stream),
+		"-vn",
+		"-c:a", "aac",
+	}
+	if profile.AudioBitrate != "" {
+		args = append(args, "-b:a", profile.AudioBitrate)
+	}
+	args = append(args, segmentArgs(profile, req.OutputDir, track.Label)...)
+	args = append(args,
+		"-progress", "pipe:1",
+		"-nostats",
+		playlist,
+	)
+
+	return runFFmpeg(ctx, args, onProgress)
+}
+
+func analyzeAudioRendition(ctx context.Context, outputDir string, track *audioRendition) error {
+	stats, err := analyzePlaylist(ctx, outputDir, track.Label)
+	if err != nil {
+		return err
+	}
+
+	track.Segments = stats.Segments
+	track.PeakBandwidth = stats.PeakBandwidth
+	track.AverageBandwidth = stats.AverageBandwidth
+	track.Codecs = stats.Codecs
+	return nil
+}
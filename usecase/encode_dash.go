@@ -0,0 +1,188 @@
+package usecase
+
+import (
+	"encoding/xml"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/util"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const dashManifestName = "manifest.mpd"
+
+// dashTimescale expresses segment timing in milliseconds.
+const dashTimescale = 1000
+
+type mpd struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	Xmlns                     string   `xml:"xmlns,attr"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	Period                    mpdPeriod
+}
+
+type mpdPeriod struct {
+	XMLName        xml.Name           `xml:"Period"`
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID               int                 `xml:"id,attr"`
+	ContentType      string              `xml:"contentType,attr"`
+	MimeType         string              `xml:"mimeType,attr"`
+	Lang             string              `xml:"lang,attr,omitempty"`
+	SegmentAlignment bool                `xml:"segmentAlignment,attr"`
+	StartWithSAP     int                 `xml:"startWithSAP,attr"`
+	Representations  []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID          string         `xml:"id,attr"`
+	Bandwidth   int            `xml:"bandwidth,attr"`
+	Width       int            `xml:"width,attr,omitempty"`
+	Height      int            `xml:"height,attr,omitempty"`
+	FrameRate   string         `xml:"frameRate,attr,omitempty"`
+	Codecs      string         `xml:"codecs,attr"`
+	SegmentList mpdSegmentList `xml:"SegmentList"`
+}
+
+type mpdSegmentList struct {
+	Timescale      int                `xml:"timescale,attr"`
+	Initialization mpdURL             `xml:"Initialization"`
+	Timeline       []mpdTimelineEntry `xml:"SegmentTimeline>S"`
+	SegmentURLs    []mpdSegmentURL    `xml:"SegmentURL"`
+}
+
+type mpdURL struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+type mpdTimelineEntry struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// generateDashManifest writes a static MPD that references the CMAF segments
+// produced for HLS, so both protocols share one copy of the media. Each codec
+// gets its own adaptation set since players cannot switch between codecs, and
+// each audio language gets its own audio adaptation set.
+func generateDashManifest(outputDir string, renditions []rendition, audio []audioRendition, segmentDuration int) error {
+	manifest := mpd{
+		Xmlns:         "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:      "urn:mpeg:dash:profile:isoff-main:2011",
+		Type:          "static",
+		MinBufferTime: dashDuration(float64(segmentDuration)),
+		Period: mpdPeriod{
+			ID:    "0",
+			Start: "PT0S",
+		},
+	}
+
+	sets := make(map[string]int) // codec family to adaptation set index
+
+	var duration float64
+	for _, res := range renditions {
+		list, total := dashSegmentList(res.Label, res.Segments)
+		duration = max(duration, total)
+
+		representation := mpdRepresentation{
+			ID:          res.Label,
+			Bandwidth:   res.PeakBandwidth,
+			Width:       res.Width,
+			Height:      res.Height,
+			Codecs:      res.Codecs,
+			SegmentList: list,
+		}
+		if res.FrameRate > 0 {
+			representation.FrameRate = fmt.Sprintf("%.3f", res.FrameRate)
+		}
+
+		family := entity.CodecFamily(res.Codec)
+		index, ok := sets[family]
+		if !ok {
+			index = len(manifest.Period.AdaptationSets)
+			sets[family] = index
+			manifest.Period.AdaptationSets = append(manifest.Period.AdaptationSets, mpdAdaptationSet{
+				ID:               index,
+				ContentType:      "video",
+				MimeType:         "video/mp4",
+				SegmentAlignment: true,
+				StartWithSAP:     1,
+			})
+		}
+
+		set := &manifest.Period.AdaptationSets[index]
+		set.Representations = append(set.Representations, representation)
+	}
+
+	languages := make(map[string]int) // audio language to adaptation set index
+	for _, track := range audio {
+		list, total := dashSegmentList(track.Label, track.Segments)
+		duration = max(duration, total)
+
+		index, ok := languages[track.Language]
+		if !ok {
+			index = len(manifest.Period.AdaptationSets)
+			languages[track.Language] = index
+			manifest.Period.AdaptationSets = append(manifest.Period.AdaptationSets, mpdAdaptationSet{
+				ID:               index,
+				ContentType:      "audio",
+				MimeType:         "audio/mp4",
+				Lang:             track.Language,
+				SegmentAlignment: true,
+				StartWithSAP:     1,
+			})
+		}
+
+		set := &manifest.Period.AdaptationSets[index]
+		set.Representations = append(set.Representations, mpdRepresentation{
+			ID:          track.Label,
+			Bandwidth:   track.PeakBandwidth,
+			Codecs:      track.Codecs,
+			SegmentList: list,
+		})
+	}
+	manifest.MediaPresentationDuration = dashDuration(duration)
+
+	data, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal mpd: %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(outputDir, dashManifestName), append([]byte(xml.Header), data...), 0644)
+}
+
+// dashSegmentList builds the segment list of one rendition and returns it
+// with the rendition duration in seconds.
+func dashSegmentList(label string, segments []util.MediaSegment) (mpdSegmentList, float64) {
+	list := mpdSegmentList{
+		Timescale:      dashTimescale,
+		Initialization: mpdURL{SourceURL: fmt.Sprintf("%s_init.mp4", label)},
+	}
+
+	var start float64
+	for _, segment := range segments {
+		t := int64(math.Round(start * dashTimescale))
+		end := int64(math.Round((start + segment.Duration) * dashTimescale))
+		list.Timeline = append(list.Timeline, mpdTimelineEntry{T: t, D: end - t})
+		list.SegmentURLs = append(list.SegmentURLs, mpdSegmentURL{Media: segment.URI})
+		start += segment.Duration
+	}
+
+	return list, start
+}
+
+// dashDuration formats seconds as an xs:duration, e.g. PT12.500S.
+func dashDuration(seconds float64) string {
+	return fmt.Sprintf("PT%.3fS", seconds)
+}
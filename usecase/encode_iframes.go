@@ -0,0 +1,246 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/util"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// iframeEntry is one I-frame of an I-frame playlist, addressed as a byte range
+// of an existing media segment. MapLength is the length of the MPEG-TS program
+// tables at the start of the segment, mapped as its initialization section.
+type iframeEntry struct {
+	URI       string
+	Offset    int64
+	Length    int64
+	Duration  float64
+	MapLength int64
+}
+
+func iframePlaylistName(label string) string {
+	return fmt.Sprintf("%s_iframes.m3u8", label)
+}
+
+// generateIFramePlaylist writes the I-frame playlist of a rendition and
+// records its name and peak bandwidth for the master playlist.
+func generateIFramePlaylist(ctx context.Context, outputDir string, profile *entity.EncodingProfile, res *rendition) error {
+	fmp4 := profile.SegmentFormat == entity.SegmentFormatFMP4
+
+	var entries []iframeEntry
+	for _, segment := range res.Segments {
+		packets, size, err := probeSegmentPackets(ctx, outputDir, res.Label, segment.URI, fmp4)
+		if err != nil {
+			return fmt.Errorf("probe %s: %w", segment.URI, err)
+		}
+		segmentEntries := segmentIFrames(segment, packets, size, fmp4)
+		if !fmp4 {
+			header, err := tsHeaderLength(filepath.Join(outputDir, segment.URI))
+			if err != nil {
+				return fmt.Errorf("read %s: %w", segment.URI, err)
+			}
+			if header == 0 {
+				return fmt.Errorf("%s does not start with program tables", segment.URI)
+			}
+			for i := range segmentEntries {
+				segmentEntries[i].MapLength = header
+			}
+		}
+		entries = append(entries, segmentEntries...)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no keyframes found")
+	}
+
+	initSegment := ""
+	if fmp4 {
+		initSegment = fmt.Sprintf("%s_init.mp4", res.Label)
+	}
+
+	playlist, peak := iframePlaylist(entries, initSegment)
+	if err := os.WriteFile(filepath.Join(outputDir, iframePlaylistName(res.Label)), playlist, 0644); err != nil {
+		return fmt.Errorf("write i-frame playlist: %w", err)
+	}
+
+	res.IFramePlaylist = iframePlaylistName(res.Label)
+	res.IFrameBandwidth = peak
+	return nil
+}
+
+// probeSegmentPackets returns the video packets of a segment with positions
+// relative to the segment file, together with the segment size. An fMP4
+// segment cannot be parsed without its init segment, so the two are probed
+// concatenated and the positions shifted back.
+func probeSegmentPackets(ctx context.Context, outputDir, label, name string, fmp4 bool) ([]util.VideoPacket, int64, error) {
+	segmentPath := filepath.Join(outputDir, name)
+	segment, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !fmp4 {
+		packets, err := util.ProbeVideoPackets(ctx, segmentPath)
+		return packets, int64(len(segment)), err
+	}
+
+	init, err := os.ReadFile(filepath.Join(outputDir, fmt.Sprintf("%s_init.mp4", label)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	joined, err := os.CreateTemp("", "iframe-*.mp4")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(joined.Name())
+
+	_, err = joined.Write(append(init, segment...))
+	if closeErr := joined.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	packets, err := util.ProbeVideoPackets(ctx, joined.Name())
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range packets {
+		packets[i].Pos -= int64(len(init))
+	}
+
+	return packets, int64(len(segment)), nil
+}
+
+// tsHeaderLength reads the length of the program tables an MPEG-TS segment
+// starts with.
+func tsHeaderLength(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// The tables take a handful of packets, 16 leaves plenty of room.
+	head := make([]byte, 16*188)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	return util.TSHeaderLength(head[:n]), nil
+}
+
+// segmentIFrames finds the I-frames of one segment. In MPEG-TS each keyframe
+// runs until the next video packet. An fMP4 keyframe is only decodable with
+// the fragment header in front of it, so the range starts at the segment
+// start and only the keyframe opening the segment is used.
+func segmentIFrames(segment util.MediaSegment, packets []util.VideoPacket, size int64, fmp4 bool) []iframeEntry {
+	if len(packets) == 0 {
+		return nil
+	}
+
+	start := packets[0].Time
+	for _, p := range packets {
+		start = math.Min(start, p.Time)
+	}
+	end := start + segment.Duration
+
+	var entries []iframeEntry
+	for i, p := range packets {
+		if !p.Keyframe {
+			continue
+		}
+
+		entry := iframeEntry{URI: segment.URI, Offset: p.Pos}
+		if fmp4 {
+			entry.Offset = 0
+			entry.Length = p.Pos + p.Size
+			entry.Duration = segment.Duration
+			return []iframeEntry{entry}
+		}
+
+		next := size
+		if i+1 < len(packets) {
+			next = packets[i+1].Pos
+		}
+		entry.Length = next - p.Pos
+
+		nextKey := end
+		for _, later := range packets[i+1:] {
+			if later.Keyframe {
+				nextKey = later.Time
+				break
+			}
+		}
+		entry.Duration = nextKey - p.Time
+
+		if entry.Length > 0 && entry.Duration > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// iframePlaylist renders the entries and returns the playlist with its peak
+// bitrate in bits per second. MPEG-TS entries map the program tables of their
+// segment, fMP4 ones the init segment, as every I-frame needs a Media
+// Initialization Section to be decoded on its own.
+func iframePlaylist(entries []iframeEntry, initSegment string) ([]byte, int) {
+	target := 1.0
+	peak := 0
+	mapped := initSegment != ""
+	for _, entry := range entries {
+		target = math.Max(target, entry.Duration)
+		peak = max(peak, int(math.Ceil(float64(entry.Length*8)/entry.Duration)))
+		mapped = mapped || entry.MapLength > 0
+	}
+
+	// EXT-X-BYTERANGE needs version 4, EXT-X-MAP in an I-frames only
+	// playlist version 5.
+	version := 4
+	if mapped {
+		version = 5
+	}
+
+	var builder strings.Builder
+	builder.WriteString("#EXTM3U\n")
+	builder.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", version))
+	builder.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target))))
+	builder.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	builder.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	builder.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+	if initSegment != "" {
+		builder.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", initSegment))
+	}
+	mappedSegment := ""
+	for _, entry := range entries {
+		if entry.MapLength > 0 && entry.URI != mappedSegment {
+			builder.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\",BYTERANGE=\"%d@0\"\n", entry.URI, entry.MapLength))
+			mappedSegment = entry.URI
+		}
+		builder.WriteString(fmt.Sprintf("#EXTINF:%.6f,\n#EXT-X-BYTERANGE:%d@%d\n%s\n", entry.Duration, entry.Length, entry.Offset, entry.URI))
+	}
+	builder.WriteString("#EXT-X-ENDLIST\n")
+
+	return []byte(builder.String()), peak
+}
+
+// videoCodecs drops the audio codec from a CODECS attribute value, since
+// I-frame playlists carry video only.
+func videoCodecs(codecs string) string {
+	var video []string
+	for _, codec := range strings.Split(codecs, ",") {
+		if !strings.HasPrefix(codec, "mp4a.") {
+			video = append(video, codec)
+		}
+	}
+	return strings.Join(video, ",")
+}
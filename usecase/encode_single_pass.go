@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// singlePassVariant is the job variant reported while every rung is encoded
+// by one ffmpeg process.
+const singlePassVariant = "all"
+
+// encodeSinglePass decodes the source once, splits the decoded video into one
+// scaled branch per rendition and lets the HLS muxer write every variant
+// playlist from a single ffmpeg process.
+func (u *encodeUseCase) encodeSinglePass(ctx context.Context, req *model.EncodeRequest, profile *entity.EncodingProfile, info *util.MediaInfo, renditions []rendition, audio []audioRendition, onProgress func(util.FFmpegProgress)) error {
+	return runFFmpeg(ctx, singlePassArgs(req, profile, info, renditions, audio), onProgress)
+}
+
+// singlePassArgs builds the ffmpeg arguments for encodeSinglePass. Audio is
+// muxed into every variant unless separate audio renditions are given, in
+// which case each source track becomes its own variant. ffmpeg is not asked
+// for a master playlist with -master_pl_name: generateMasterPlaylist writes
+// it once the variants are measured, with the real BANDWIDTH and CODECS,
+// the same way as for the per-rendition encode.
+func singlePassArgs(req *model.EncodeRequest, profile *entity.EncodingProfile, info *util.MediaInfo, renditions []rendition, audio []audioRendition) []string {
+	var filter strings.Builder
+	filter.WriteString(fmt.Sprintf("[0:v]split=%d", len(renditions)))
+	for i := range renditions {
+		filter.WriteString(fmt.Sprintf("[v%d]", i))
+	}
+	for i, res := range renditions {
+		filter.WriteString(fmt.Sprintf(";[v%d]scale=w=%d:h=%d,setsar=1[v%dout]", i, res.Width, res.Height, i))
+	}
+
+	args := []string{
+		"-i", req.InputPath,
+		"-filter_complex", filter.String(),
+	}
+
+	muxedAudio := info.HasAudio && !profile.SeparateAudio
+	streamMap := make([]string, 0, len(renditions)+len(audio))
+	for i, res := range renditions {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			fmt.Sprintf("-c:v:%d", i), res.Codec,
+			fmt.Sprintf("-b:v:%d", i), res.Bitrate,
+		)
+		args = append(args, codecArgs(res.Codec, fmt.Sprintf(":v:%d", i))...)
+
+		entry := fmt.Sprintf("v:%d", i)
+		if muxedAudio {
+			args = append(args, "-map", "0:a:0")
+			entry += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, fmt.Sprintf("%s,name:%s", entry, res.Label))
+	}
+
+	for i, track := range audio {
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", track.Stream))
+		streamMap = append(streamMap, fmt.Sprintf("a:%d,name:%s", i, track.Label))
+	}
+
+	if muxedAudio || len(audio) > 0 {
+		args = append(args, "-c:a", "aac")
+		if profile.AudioBitrate != "" {
+			args = append(args, "-b:a", profile.AudioBitrate)
+		}
+	}
+	if profile.Preset != "" {
+		args = append(args, "-preset", profile.Preset)
+	}
+	args = append(args, keyframeArgs(profile, info)...)
+
+	args = append(args, "-f", "hls")
+	args = append(args, segmentArgs(profile, req.OutputDir, "%v")...)
+	return append(args,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		"-progress", "pipe:1",
+		"-nostats",
+		filepath.Join(req.OutputDir, "%v.m3u8"),
+	)
+}
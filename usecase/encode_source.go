@@ -0,0 +1,63 @@
+package usecase
+
+import (
+	"context"
+	"ffmpeg-hls/util"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// downloadSource copies the object at key to path.
+func downloadSource(ctx context.Context, store util.ObjectStore, key, path string) error {
+	object, err := store.GetObject(ctx, key)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", key, err)
+	}
+	defer object.Close()
+
+	if _, err := saveSource(path, object); err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	return nil
+}
+
+// removeSource deletes the uploaded source at key once its job finished. A
+// failed job is not retried from it, the video has to be uploaded again, so
+// the object is removed either way.
+func removeSource(ctx context.Context, store util.ObjectStore, key string) {
+	if err := store.RemoveObject(ctx, key); err != nil {
+		log.Printf("[USECASE][RemoveSource %s] %v", key, err)
+	}
+}
+
+// sourcePath is where the source of a video is kept while it is encoded.
+func (u *encodeUseCase) sourcePath(videoID string) string {
+	return filepath.Join(u.sourceDir, videoID)
+}
+
+// saveSource writes reader to path through a temporary file, so an
+// interrupted transfer never leaves a truncated source behind. Written bytes
+// are counted even when the copy fails.
+func saveSource(path string, reader io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.download")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+
+	return written, os.Rename(file.Name(), path)
+}
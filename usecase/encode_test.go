@@ -2,11 +2,21 @@ package usecase
 
 import (
 	"context"
+	"ffmpeg-hls/entity"
 	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
 	"ffmpeg-hls/util"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -18,16 +28,585 @@ func TestEncode(t *testing.T) {
 
 	serverKey := os.Getenv("HTTP_PROTOCOL") + os.Getenv("BASE_IP_URL") + ":" + os.Getenv("PORT")
 	log.Print(serverKey)
-	minio := util.InitMinio()
+	objectStore := util.InitObjectStore()
 	req := &model.EncodeRequest{
 		APIServer: serverKey,
 		VideoID:   "sample-5s.mp4",
 	}
 
-	encodeUC := NewEncodeUseCase(minio)
+	db := openTestDB(t, objectStore)
+	profileRepo, err := repository.NewProfileRepository("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobUC := NewJobUseCase(repository.NewJobRepository(db))
+	encodeUC := NewEncodeUseCase(objectStore, jobUC, profileRepo, repository.NewVideoRepository(db), repository.NewFileKeyStore(t.TempDir()), util.InitEncoders(), util.ImportConfig{})
 	ctx := context.Background()
-	err := encodeUC.EncodeAndUpload(ctx, req)
+	if _, err := jobUC.CreateJob(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	err = encodeUC.EncodeAndUpload(ctx, req)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+func TestBuildLadder(t *testing.T) {
+	profileRepo, err := repository.NewProfileRepository("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := profileRepo.GetByName("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ladder := profile.Ladder
+
+	tests := []struct {
+		name string
+		info util.MediaInfo
+		want []string
+	}{
+		{
+			name: "landscape 1080p keeps every rung",
+			info: util.MediaInfo{Width: 1920, Height: 1080},
+			want: []string{"360p:640x360", "480p:854x480", "720p:1280x720", "1080p:1920x1080"},
+		},
+		{
+			name: "480p phone clip is not upscaled",
+			info: util.MediaInfo{Width: 854, Height: 480},
+			want: []string{"360p:640x360", "480p:854x480"},
+		},
+		{
+			name: "vertical 9:16 keeps its aspect ratio",
+			info: util.MediaInfo{Width: 720, Height: 1280},
+			want: []string{"360p:360x640", "480p:480x854", "720p:720x1280"},
+		},
+		{
+			name: "rotated landscape coded frame is treated as vertical",
+			info: util.MediaInfo{Width: 1280, Height: 720, Rotation: 90},
+			want: []string{"360p:360x640", "480p:480x854", "720p:720x1280"},
+		},
+		{
+			name: "tiny source is encoded at its own size",
+			info: util.MediaInfo{Width: 426, Height: 240},
+			want: []string{"240p:426x240"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range buildLadder(&tt.info, ladder) {
+				got = append(got, fmt.Sprintf("%s:%dx%d", r.Label, r.Width, r.Height))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSinglePassArgs(t *testing.T) {
+	req := &model.EncodeRequest{InputPath: "in.mp4", OutputDir: "out"}
+	profile := &entity.EncodingProfile{Codec: "libx264", SegmentDuration: 4, AudioBitrate: "128k"}
+	renditions := []rendition{
+		{Label: "360p", Codec: "libx264", Bitrate: "500k", Width: 640, Height: 360},
+		{Label: "720p", Codec: "libx264", Bitrate: "2000k", Width: 1280, Height: 720},
+		{Label: "720p-hevc", Codec: "libx265", Bitrate: "1400k", Width: 1280, Height: 720},
+	}
+
+	args := strings.Join(singlePassArgs(req, profile, &util.MediaInfo{HasAudio: true}, renditions, nil), " ")
+	for _, want := range []string{
+		"-filter_complex [0:v]split=3[v0][v1][v2];[v0]scale=w=640:h=360,setsar=1[v0out];[v1]scale=w=1280:h=720,setsar=1[v1out];[v2]scale=w=1280:h=720,setsar=1[v2out]",
+		"-map [v1out] -c:v:1 libx264 -b:v:1 2000k -map 0:a:0",
+		"-map [v2out] -c:v:2 libx265 -b:v:2 1400k -tag:v:2 hvc1 -x265-params:v:2 scenecut=0:open-gop=0",
+		"-var_stream_map v:0,a:0,name:360p v:1,a:1,name:720p v:2,a:2,name:720p-hevc",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args missing %q:\n%s", want, args)
+		}
+	}
+
+	silent := strings.Join(singlePassArgs(req, profile, &util.MediaInfo{}, renditions, nil), " ")
+	if strings.Contains(silent, "0:a:0") || !strings.Contains(silent, "-var_stream_map v:0,name:360p v:1,name:720p v:2,name:720p-hevc") {
+		t.Errorf("unexpected args for source without audio:\n%s", silent)
+	}
+
+	separate := *profile
+	separate.SeparateAudio = true
+	info := &util.MediaInfo{HasAudio: true, AudioStreams: []util.AudioStream{{Language: "eng"}, {Language: "fra"}}}
+	split := strings.Join(singlePassArgs(req, &separate, info, renditions, buildAudioRenditions(info)), " ")
+	for _, want := range []string{
+		"-map [v0out] -c:v:0 libx264 -b:v:0 500k -map [v1out]",
+		"-map 0:a:0 -map 0:a:1 -c:a aac -b:a 128k",
+		"-var_stream_map v:0,name:360p v:1,name:720p v:2,name:720p-hevc a:0,name:audio_0 a:1,name:audio_1",
+	} {
+		if !strings.Contains(split, want) {
+			t.Errorf("args missing %q:\n%s", want, split)
+		}
+	}
+}
+
+func TestBuildAudioRenditions(t *testing.T) {
+	info := &util.MediaInfo{AudioStreams: []util.AudioStream{
+		{Language: "eng", Title: "English Stereo", Channels: 2},
+		{Language: "fra", Channels: 6, Default: true},
+		{Channels: 2},
+		{Language: "en\"\n", Title: "Director's \"cut\",\nfinal", Channels: 2},
+	}}
+
+	audio := buildAudioRenditions(info)
+	want := []audioRendition{
+		{Label: "audio_0", Name: "English Stereo", Language: "eng", Channels: 2, Stream: 0},
+		{Label: "audio_1", Name: "fra", Language: "fra", Channels: 6, Default: true, Stream: 1},
+		{Label: "audio_2", Name: "Audio 3", Channels: 2, Stream: 2},
+		{Label: "audio_3", Name: "Director's cut final", Channels: 2, Stream: 3},
+	}
+	if !reflect.DeepEqual(audio, want) {
+		t.Fatalf("unexpected audio renditions: %+v", audio)
+	}
+
+	info.AudioStreams[1].Default = false
+	if audio := buildAudioRenditions(info); !audio[0].Default || audio[1].Default {
+		t.Fatalf("expected first track to become the default: %+v", audio)
+	}
+}
+
+func TestEncryptVariant(t *testing.T) {
+	dir := t.TempDir()
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.000000,\n360p_000.ts\n#EXTINF:2.000000,\n360p_001.ts\n#EXT-X-ENDLIST\n"
+	files := map[string]string{"360p.m3u8": playlist, "360p_000.ts": "first", "360p_001.ts": "second"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := &model.EncodeRequest{OutputDir: dir, APIServer: "http://api", VideoID: "vid"}
+	keys, err := encryptVariant(req, "360p", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "360p.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "#EXT-X-TARGETDURATION:4\n#EXT-X-KEY:METHOD=AES-128,URI=\"http://api/videos/vid/keys/enc_360p.key\",IV=0x") {
+		t.Fatalf("key tag not inserted before first segment:\n%s", data)
+	}
+
+	if key := keys["enc_360p.key"]; len(keys) != 1 || len(key) != 16 {
+		t.Fatalf("expected one 16 byte key, got %v", keys)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "enc_360p.key")); !os.IsNotExist(err) {
+		t.Fatal("key must not be written next to the segments")
+	}
+	if segment, _ := os.ReadFile(filepath.Join(dir, "360p_001.ts")); len(segment) != 16 {
+		t.Fatalf("segment was not encrypted, got %d bytes", len(segment))
+	}
+}
+
+func TestEncryptVariantKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("360p_%03d.ts", i)
+		playlist.WriteString(fmt.Sprintf("#EXTINF:4.000000,\n%s\n", name))
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("segment"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "360p.m3u8"), []byte(playlist.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &model.EncodeRequest{OutputDir: dir, APIServer: "http://api", VideoID: "vid"}
+	generated, err := encryptVariant(req, "360p", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "360p.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	var keys []string
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-KEY") {
+			keys = append(keys, line)
+			if next := lines[i+2]; !strings.HasSuffix(next, ".ts") {
+				t.Fatalf("key tag not followed by a segment: %q", next)
+			}
+		}
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys for 5 segments rotated every 2, got %d:\n%s", len(keys), data)
+	}
+	if !strings.Contains(keys[2], `URI="http://api/videos/vid/keys/enc_360p_002.key"`) {
+		t.Fatalf("unexpected key rotation:\n%s", data)
+	}
+
+	for i := 0; i < 3; i++ {
+		if key := generated[fmt.Sprintf("enc_360p_%03d.key", i)]; len(key) != 16 {
+			t.Fatalf("missing key %d: %v", i, generated)
+		}
+	}
+}
+
+func TestGenerateMasterPlaylist(t *testing.T) {
+	dir := t.TempDir()
+	renditions := []rendition{
+		{Label: "360p", Width: 640, Height: 360, PeakBandwidth: 700000, AverageBandwidth: 550000, Codecs: "avc1.64001e,mp4a.40.2", FrameRate: 29.97},
+		{Label: "720p", Width: 1280, Height: 720, PeakBandwidth: 2600000, AverageBandwidth: 2100000, Codecs: "avc1.64001f,mp4a.40.2", FrameRate: 29.97},
+	}
+
+	if err := generateMasterPlaylist(dir, renditions, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=700000,AVERAGE-BANDWIDTH=550000,RESOLUTION=640x360,FRAME-RATE=29.970,CODECS=\"avc1.64001e,mp4a.40.2\"\n360p.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2600000,AVERAGE-BANDWIDTH=2100000,RESOLUTION=1280x720,FRAME-RATE=29.970,CODECS=\"avc1.64001f,mp4a.40.2\"\n720p.m3u8\n"
+	if string(data) != want {
+		t.Fatalf("unexpected master playlist:\n%s", data)
+	}
+}
+
+func TestGenerateMasterPlaylistSeparateAudio(t *testing.T) {
+	dir := t.TempDir()
+	renditions := []rendition{
+		{Label: "360p", Width: 640, Height: 360, PeakBandwidth: 600000, AverageBandwidth: 450000, Codecs: "avc1.64001e"},
+	}
+	audio := []audioRendition{
+		{Label: "audio_0", Name: "English", Language: "eng", Channels: 2, Default: true, PeakBandwidth: 130000, AverageBandwidth: 128000, Codecs: "mp4a.40.2"},
+		{Label: "audio_1", Name: "Commentary", Language: "eng", Channels: 6, PeakBandwidth: 200000, AverageBandwidth: 190000, Codecs: "mp4a.40.2"},
+	}
+
+	if err := generateMasterPlaylist(dir, renditions, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "#EXTM3U\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"English\",LANGUAGE=\"eng\",DEFAULT=YES,AUTOSELECT=YES,CHANNELS=\"2\",URI=\"audio_0.m3u8\"\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"Commentary\",LANGUAGE=\"eng\",DEFAULT=NO,AUTOSELECT=YES,CHANNELS=\"6\",URI=\"audio_1.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,AVERAGE-BANDWIDTH=640000,RESOLUTION=640x360,CODECS=\"avc1.64001e,mp4a.40.2\",AUDIO=\"audio\"\n360p.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=130000,AVERAGE-BANDWIDTH=128000,CODECS=\"mp4a.40.2\",AUDIO=\"audio\"\naudio_0.m3u8\n"
+	if string(data) != want {
+		t.Fatalf("unexpected master playlist:\n%s", data)
+	}
+}
+
+func TestVerifySegmentAlignment(t *testing.T) {
+	write := func(dir, label string, durations ...string) {
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n")
+		for i, d := range durations {
+			b.WriteString(fmt.Sprintf("#EXTINF:%s,\n%s_%03d.ts\n", d, label, i))
+		}
+		if err := os.WriteFile(filepath.Join(dir, label+".m3u8"), []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renditions := []rendition{{Label: "360p"}, {Label: "720p"}}
+
+	aligned := t.TempDir()
+	write(aligned, "360p", "4.000000", "4.000000", "1.500000")
+	write(aligned, "720p", "4.004000", "3.996000", "1.500000")
+	if err := verifySegmentAlignment(aligned, renditions, 30); err != nil {
+		t.Fatalf("expected aligned renditions, got %v", err)
+	}
+
+	diverged := t.TempDir()
+	write(diverged, "360p", "4.000000", "4.000000", "1.500000")
+	write(diverged, "720p", "4.800000", "3.200000", "1.500000")
+	if err := verifySegmentAlignment(diverged, renditions, 30); err == nil {
+		t.Fatal("expected divergent boundaries to fail")
+	}
+
+	short := t.TempDir()
+	write(short, "360p", "4.000000", "4.000000")
+	write(short, "720p", "8.000000")
+	if err := verifySegmentAlignment(short, renditions, 30); err == nil {
+		t.Fatal("expected segment count mismatch to fail")
+	}
+}
+
+func TestGenerateDashManifest(t *testing.T) {
+	dir := t.TempDir()
+	renditions := []rendition{{
+		Label:         "720p",
+		Codec:         "libx264",
+		Width:         1280,
+		Height:        720,
+		PeakBandwidth: 2500000,
+		Codecs:        "avc1.64001f,mp4a.40.2",
+		FrameRate:     25,
+		Segments: []util.MediaSegment{
+			{URI: "720p_000.m4s", Duration: 4},
+			{URI: "720p_001.m4s", Duration: 1.5},
+		},
+	}, {
+		Label:         "720p-av1",
+		Codec:         "libsvtav1",
+		Width:         1280,
+		Height:        720,
+		PeakBandwidth: 1500000,
+		Codecs:        "av01.0.08M.08,mp4a.40.2",
+		Segments:      []util.MediaSegment{{URI: "720p-av1_000.m4s", Duration: 5.5}},
+	}}
+	audio := []audioRendition{{
+		Label:         "audio_0",
+		Language:      "eng",
+		PeakBandwidth: 130000,
+		Codecs:        "mp4a.40.2",
+		Segments:      []util.MediaSegment{{URI: "audio_0_000.m4s", Duration: 5.5}},
+	}}
+
+	if err := generateDashManifest(dir, renditions, audio, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, dashManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`mediaPresentationDuration="PT5.500S"`,
+		`<Representation id="720p" bandwidth="2500000" width="1280" height="720" frameRate="25.000" codecs="avc1.64001f,mp4a.40.2">`,
+		`<Initialization sourceURL="720p_init.mp4"></Initialization>`,
+		`<S t="4000" d="1500"></S>`,
+		`<SegmentURL media="720p_001.m4s"></SegmentURL>`,
+		`<AdaptationSet id="1" contentType="video"`,
+		`<Representation id="720p-av1"`,
+		`<AdaptationSet id="2" contentType="audio" mimeType="audio/mp4" lang="eng"`,
+		`<Representation id="audio_0" bandwidth="130000" codecs="mp4a.40.2">`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("manifest missing %s:\n%s", want, data)
+		}
+	}
+}
+
+func TestThumbnailVTT(t *testing.T) {
+	info := &util.MediaInfo{Width: 1920, Height: 1080, Duration: 512 * time.Second}
+	layout := newThumbnailLayout(info)
+	if layout.Width != 160 || layout.Height != 90 || layout.Frames != 103 {
+		t.Fatalf("unexpected layout: %+v", layout)
+	}
+
+	args := strings.Join(spriteArgs(&model.EncodeRequest{InputPath: "in.mp4", OutputDir: "out"}, layout), " ")
+	if !strings.Contains(args, "-vf fps=1/5,scale=w=160:h=90,setsar=1,tile=10x10") {
+		t.Errorf("unexpected sprite args: %s", args)
+	}
+
+	track := string(thumbnailVTT(layout, info.Duration))
+	for _, want := range []string{
+		"WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nthumbs_000.jpg#xywh=0,0,160,90\n",
+		"\n00:00:55.000 --> 00:01:00.000\nthumbs_000.jpg#xywh=160,90,160,90\n",
+		"\n00:08:20.000 --> 00:08:25.000\nthumbs_001.jpg#xywh=0,0,160,90\n",
+		"\n00:08:30.000 --> 00:08:32.000\nthumbs_001.jpg#xywh=320,0,160,90\n",
+	} {
+		if !strings.Contains(track, want) {
+			t.Errorf("track missing %q", want)
+		}
+	}
+}
+
+func TestSegmentIFrames(t *testing.T) {
+	segment := util.MediaSegment{URI: "720p_001.ts", Duration: 4}
+	packets := []util.VideoPacket{
+		{Time: 5.4, Pos: 376, Size: 40000, Keyframe: true},
+		{Time: 5.44, Pos: 41360, Size: 3000},
+		{Time: 7.4, Pos: 200000, Size: 38000, Keyframe: true},
+		{Time: 7.44, Pos: 240000, Size: 2000},
+	}
+
+	entries := segmentIFrames(segment, packets, 300000, false)
+	want := []iframeEntry{
+		{URI: "720p_001.ts", Offset: 376, Length: 40984, Duration: 2},
+		{URI: "720p_001.ts", Offset: 200000, Length: 40000, Duration: 2},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	for i := range want {
+		if entries[i].URI != want[i].URI || entries[i].Offset != want[i].Offset || entries[i].Length != want[i].Length || math.Abs(entries[i].Duration-want[i].Duration) > 1e-9 {
+			t.Errorf("entry %d: got %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	fmp4 := segmentIFrames(util.MediaSegment{URI: "720p_001.m4s", Duration: 4}, []util.VideoPacket{
+		{Time: 4, Pos: 1200, Size: 30000, Keyframe: true},
+		{Time: 6, Pos: 90000, Size: 28000, Keyframe: true},
+	}, 150000, true)
+	if len(fmp4) != 1 || fmp4[0].Offset != 0 || fmp4[0].Length != 31200 || fmp4[0].Duration != 4 {
+		t.Fatalf("unexpected fMP4 entries: %+v", fmp4)
+	}
+
+	for i := range entries {
+		entries[i].MapLength = 376
+	}
+	entries = append(entries, iframeEntry{URI: "720p_002.ts", Offset: 376, Length: 30000, Duration: 4, MapLength: 564})
+	ts, _ := iframePlaylist(entries, "")
+	wantTS := "#EXT-X-VERSION:5\n" +
+		"#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-I-FRAMES-ONLY\n" +
+		"#EXT-X-MAP:URI=\"720p_001.ts\",BYTERANGE=\"376@0\"\n" +
+		"#EXTINF:2.000000,\n#EXT-X-BYTERANGE:40984@376\n720p_001.ts\n" +
+		"#EXTINF:2.000000,\n#EXT-X-BYTERANGE:40000@200000\n720p_001.ts\n" +
+		"#EXT-X-MAP:URI=\"720p_002.ts\",BYTERANGE=\"564@0\"\n" +
+		"#EXTINF:4.000000,\n#EXT-X-BYTERANGE:30000@376\n720p_002.ts\n"
+	if !strings.Contains(string(ts), wantTS) {
+		t.Fatalf("unexpected MPEG-TS playlist:\n%s", ts)
+	}
+
+	entries[0].MapLength = 0
+	if unmapped, _ := iframePlaylist(entries[:1:1], ""); !strings.Contains(string(unmapped), "#EXT-X-VERSION:4\n") || strings.Contains(string(unmapped), "EXT-X-MAP") {
+		t.Fatalf("unexpected unmapped playlist:\n%s", unmapped)
+	}
+
+	playlist, peak := iframePlaylist(fmp4, "720p_init.mp4")
+	if peak != 62400 {
+		t.Errorf("unexpected peak bandwidth %d", peak)
+	}
+	if !strings.Contains(string(playlist), "#EXT-X-VERSION:5\n") || !strings.Contains(string(playlist), "#EXT-X-I-FRAMES-ONLY\n#EXT-X-MAP:URI=\"720p_init.mp4\"\n#EXTINF:4.000000,\n#EXT-X-BYTERANGE:31200@0\n720p_001.m4s\n") {
+		t.Fatalf("unexpected playlist:\n%s", playlist)
+	}
+}
+
+func TestGenerateMasterPlaylistIFrames(t *testing.T) {
+	dir := t.TempDir()
+	renditions := []rendition{{
+		Label: "720p", Width: 1280, Height: 720, PeakBandwidth: 2600000, AverageBandwidth: 2100000,
+		Codecs: "avc1.64001f,mp4a.40.2", IFramePlaylist: "720p_iframes.m3u8", IFrameBandwidth: 180000,
+	}}
+
+	if err := generateMasterPlaylist(dir, renditions, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "720p.m3u8\n#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=180000,RESOLUTION=1280x720,CODECS=\"avc1.64001f\",URI=\"720p_iframes.m3u8\"\n") {
+		t.Fatalf("unexpected master playlist:\n%s", data)
+	}
+}
+
+// flakyStore fails the first PutObject of every key and records the order
+// in which objects were stored.
+type flakyStore struct {
+	util.ObjectStore
+
+	mu     sync.Mutex
+	failed map[string]bool
+	stored []string
+}
+
+func (s *flakyStore) PutObject(ctx context.Context, key string, reader io.Reader, size int64, opts util.PutOptions) error {
+	s.mu.Lock()
+	if !s.failed[key] {
+		s.failed[key] = true
+		s.mu.Unlock()
+		io.CopyN(io.Discard, reader, 1)
+		return fmt.Errorf("connection reset")
+	}
+	s.stored = append(s.stored, key)
+	s.mu.Unlock()
+
+	return s.ObjectStore.PutObject(ctx, key, reader, size, opts)
+}
+
+func TestUploadDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"master.m3u8":    "#EXTM3U\n",
+		"360p.m3u8":      "#EXTM3U\n",
+		"360p_000.ts":    "segment 0",
+		"360p_001.ts":    "segment 1",
+		"thumbs_000.jpg": "sheet",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := &flakyStore{ObjectStore: util.NewLocalStore(t.TempDir(), "", nil), failed: map[string]bool{}}
+	up := newUploader(store)
+	up.backoff = time.Millisecond
+
+	ctx := context.Background()
+	if err := up.uploadDir(ctx, dir, "videos/v"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range files {
+		obj, err := store.GetObject(ctx, "videos/v/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(obj)
+		obj.Close()
+		if string(data) != want {
+			t.Errorf("%s: got %q after retry, want %q", name, data, want)
+		}
+	}
+
+	for _, key := range store.stored[:3] {
+		if strings.HasSuffix(key, ".m3u8") {
+			t.Fatalf("playlist uploaded before its media: %v", store.stored)
+		}
+	}
+
+	up.attempts = 1
+	store.failed = map[string]bool{}
+	if err := up.uploadDir(ctx, dir, "videos/w"); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("expected the upload to fail without retries, got %v", err)
+	}
+}
+
+func TestValidateRequestFilename(t *testing.T) {
+	u := newTestEncodeUseCase(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"", "..", "../lecture1.mp4", `C:\videos\lecture1.mp4`, "lecture\n1.mp4", strings.Repeat("a", 256)} {
+		if err := u.ValidateRequest(ctx, &model.EncodeRequest{OriginalFilename: name}); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+
+	first := &model.EncodeRequest{OriginalFilename: "lecture 1 (final).mp4"}
+	second := &model.EncodeRequest{OriginalFilename: "lecture 1 (final).mp4"}
+	for _, req := range []*model.EncodeRequest{first, second} {
+		if err := u.ValidateRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.VideoID == "" || first.VideoID == second.VideoID || strings.Contains(first.VideoID, "lecture") {
+		t.Fatalf("expected distinct generated IDs, got %q and %q", first.VideoID, second.VideoID)
+	}
+
+	first.APIServer = "http://api:5000"
+	urls := u.PlaybackURLs(ctx, first)
+	if urls.HLS != "http://api:5000/videos/"+first.VideoID+"/playlists/master.m3u8" || urls.DASH != "" {
+		t.Fatalf("unexpected playback urls %+v", urls)
+	}
+}
@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	thumbnailVariant  = "thumbnails"
+	thumbnailTrack    = "thumbnails.vtt"
+	thumbnailPoster   = "poster.jpg"
+	thumbnailInterval = 5 * time.Second
+	thumbnailWidth    = 160
+	thumbnailColumns  = 10
+	thumbnailRows     = 10
+	posterMaxWidth    = 1280
+)
+
+// thumbnailLayout describes how preview frames are tiled into sprite sheets.
+type thumbnailLayout struct {
+	Width    int
+	Height   int
+	Columns  int
+	Rows     int
+	Interval time.Duration
+	Frames   int
+}
+
+func newThumbnailLayout(info *util.MediaInfo) thumbnailLayout {
+	width, height := info.DisplaySize()
+	frames := int(math.Ceil(info.Duration.Seconds() / thumbnailInterval.Seconds()))
+
+	return thumbnailLayout{
+		Width:    thumbnailWidth,
+		Height:   evenDimension(float64(thumbnailWidth) * float64(height) / float64(width)),
+		Columns:  thumbnailColumns,
+		Rows:     thumbnailRows,
+		Interval: thumbnailInterval,
+		Frames:   max(frames, 1),
+	}
+}
+
+func (l thumbnailLayout) perSheet() int {
+	return l.Columns * l.Rows
+}
+
+func (l thumbnailLayout) sheetName(index int) string {
+	return fmt.Sprintf("thumbs_%03d.jpg", index)
+}
+
+// generateThumbnails writes the sprite sheets, the WebVTT thumbnail track that
+// points into them and a poster frame to the output directory.
+func (u *encodeUseCase) generateThumbnails(ctx context.Context, req *model.EncodeRequest, info *util.MediaInfo) error {
+	layout := newThumbnailLayout(info)
+	noProgress := func(util.FFmpegProgress) {}
+
+	if err := runFFmpeg(ctx, spriteArgs(req, layout), noProgress); err != nil {
+		return fmt.Errorf("sprites: %w", err)
+	}
+
+	// The fps filter rounds the last frame, so trust what was written.
+	sheets, err := filepath.Glob(filepath.Join(req.OutputDir, "thumbs_*.jpg"))
+	if err != nil {
+		return fmt.Errorf("list sprites: %w", err)
+	}
+	if len(sheets) == 0 {
+		return fmt.Errorf("ffmpeg wrote no sprite sheets")
+	}
+	layout.Frames = min(layout.Frames, len(sheets)*layout.perSheet())
+
+	track := thumbnailVTT(layout, info.Duration)
+	if err := os.WriteFile(filepath.Join(req.OutputDir, thumbnailTrack), track, 0644); err != nil {
+		return fmt.Errorf("write thumbnail track: %w", err)
+	}
+
+	if err := runFFmpeg(ctx, posterArgs(req, info), noProgress); err != nil {
+		return fmt.Errorf("poster: %w", err)
+	}
+
+	return nil
+}
+
+// spriteArgs samples one frame per interval and tiles them into sheets.
+func spriteArgs(req *model.EncodeRequest, layout thumbnailLayout) []string {
+	return []string{
+		"-i", req.InputPath,
+		"-an",
+		"-vf", fmt.Sprintf("fps=1/%g,scale=w=%d:h=%d,setsar=1,tile=%dx%d", layout.Interval.Seconds(), layout.Width, layout.Height, layout.Columns, layout.Rows),
+		"-q:v", "5",
+		"-start_number", "0",
+		"-progress", "pipe:1",
+		"-nostats",
+		filepath.Join(req.OutputDir, "thumbs_%03d.jpg"),
+	}
+}
+
+// posterArgs grabs a single frame a tenth into the video, which skips the
+// black or title frames most recordings start with.
+func posterArgs(req *model.EncodeRequest, info *util.MediaInfo) []string {
+	at := min(info.Duration/10, 10*time.Second)
+	return []string{
+		"-ss", fmt.Sprintf("%.3f", at.Seconds()),
+		"-i", req.InputPath,
+		"-an",
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=w='min(%d,iw)':h=-2,setsar=1", posterMaxWidth),
+		"-q:v", "3",
+		"-update", "1",
+		"-progress", "pipe:1",
+		"-nostats",
+		filepath.Join(req.OutputDir, thumbnailPoster),
+	}
+}
+
+// thumbnailVTT builds the WebVTT track mapping each interval to its tile with
+// a #xywh= media fragment, the format understood by most web players.
+func thumbnailVTT(layout thumbnailLayout, duration time.Duration) []byte {
+	var builder strings.Builder
+	builder.WriteString("WEBVTT\n")
+
+	for i := 0; i < layout.Frames; i++ {
+		start := time.Duration(i) * layout.Interval
+		end := start + layout.Interval
+		if duration > 0 && end > duration {
+			end = duration
+		}
+		if end <= start {
+			break
+		}
+
+		position := i % layout.perSheet()
+		x := position % layout.Columns * layout.Width
+		y := position / layout.Columns * layout.Height
+
+		builder.WriteString(fmt.Sprintf("\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			util.FormatVTTTime(start), util.FormatVTTTime(end), layout.sheetName(i/layout.perSheet()), x, y, layout.Width, layout.Height))
+	}
+
+	return []byte(builder.String())
+}
@@ -0,0 +1,173 @@
+package usecase
+
+import (
+	"context"
+	"ffmpeg-hls/util"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	uploadConcurrency = 4
+	uploadAttempts    = 4
+	uploadBackoff     = 500 * time.Millisecond
+)
+
+// uploader copies an encode output directory to the object store. Files are
+// streamed from disk, several at a time, and each one is retried with
+// exponential backoff since a lecture produces thousands of segments and a
+// single transient error should not fail the whole job.
+type uploader struct {
+	store       util.ObjectStore
+	concurrency int
+	attempts    int
+	backoff     time.Duration
+}
+
+func newUploader(store util.ObjectStore) *uploader {
+	return &uploader{
+		store:       store,
+		concurrency: uploadConcurrency,
+		attempts:    uploadAttempts,
+		backoff:     uploadBackoff,
+	}
+}
+
+type uploadFile struct {
+	Path string
+	Key  string
+}
+
+// uploadDir uploads every file of dir under prefix. Playlists and manifests
+// go after the media they reference, so players never load a playlist whose
+// segments are still missing.
+func (up *uploader) uploadDir(ctx context.Context, dir, prefix string) error {
+	var media, playlists []uploadFile
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, name)
+		if err != nil {
+			return fmt.Errorf("rel path: %w", err)
+		}
+
+		file := uploadFile{Path: name, Key: fmt.Sprintf("%s/%s", prefix, filepath.ToSlash(relPath))}
+		if isPlaylistKey(file.Key) {
+			playlists = append(playlists, file)
+		} else {
+			media = append(media, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walk %s: %w", dir, err)
+	}
+
+	if err := up.uploadFiles(ctx, media); err != nil {
+		return err
+	}
+	return up.uploadFiles(ctx, playlists)
+}
+
+func isPlaylistKey(key string) bool {
+	switch path.Ext(key) {
+	case ".m3u8", ".mpd":
+		return true
+	}
+	return false
+}
+
+// uploadFiles uploads files with at most up.concurrency in flight and stops
+// at the first file that still fails after its retries.
+func (up *uploader) uploadFiles(ctx context.Context, files []uploadFile) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, max(up.concurrency, 1))
+
+	for _, file := range files {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(file uploadFile) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := up.uploadWithRetry(ctx, file); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(file)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (up *uploader) uploadWithRetry(ctx context.Context, file uploadFile) error {
+	backoff := up.backoff
+
+	var err error
+	for attempt := 1; attempt <= up.attempts; attempt++ {
+		if err = up.upload(ctx, file); err == nil {
+			return nil
+		}
+		if attempt == up.attempts || ctx.Err() != nil {
+			break
+		}
+
+		log.Printf("[USECASE][Upload] attempt %d for %s failed, retrying in %s: %v", attempt, file.Key, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	return fmt.Errorf("upload %s: %w", file.Key, err)
+}
+
+// upload streams one file. The file is reopened on every attempt so a retry
+// never resumes from a half consumed reader.
+func (up *uploader) upload(ctx context.Context, file uploadFile) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", file.Path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", file.Path, err)
+	}
+
+	return up.store.PutObject(ctx, file.Key, f, info.Size(), util.ObjectPutOptions(file.Key))
+}
@@ -1,46 +1,125 @@
 package usecase
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"ffmpeg-hls/entity"
 	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
 	"ffmpeg-hls/util"
 	errorcode "ffmpeg-hls/util/error"
 	"fmt"
-	"io/fs"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type EncodeUseCase interface {
+	ValidateRequest(ctx context.Context, req *model.EncodeRequest) error
+	PlaybackURLs(ctx context.Context, req *model.EncodeRequest) *model.PlaybackURLs
 	EncodeAndUpload(ctx context.Context, req *model.EncodeRequest) error
+	RecoverInterrupted(ctx context.Context) error
 }
 
 type encodeUseCase struct {
-	minio *util.Minio
+	objectStore       util.ObjectStore
+	jobUseCase        JobUseCase
+	profileRepository repository.ProfileRepository
+	videoRepository   repository.VideoRepository
+	keyStore          repository.KeyStore
+	encoders          map[string]bool // encoders available in the local ffmpeg build
+	importer          *sourceImporter
+	sourceDir         string // where sources are downloaded for ffmpeg
 }
 
-func NewEncodeUseCase(minio *util.Minio) EncodeUseCase {
-	return &encodeUseCase{minio: minio}
+func NewEncodeUseCase(objectStore util.ObjectStore, jobUseCase JobUseCase, profileRepository repository.ProfileRepository, videoRepository repository.VideoRepository, keyStore repository.KeyStore, encoders map[string]bool, importConfig util.ImportConfig) EncodeUseCase {
+	return &encodeUseCase{
+		objectStore:       objectStore,
+		jobUseCase:        jobUseCase,
+		profileRepository: profileRepository,
+		videoRepository:   videoRepository,
+		keyStore:          keyStore,
+		encoders:          encoders,
+		importer:          newSourceImporter(importConfig),
+		sourceDir:         ResolvePath("usecase", "tmp"),
+	}
 }
 
-var resolutions = map[string]struct {
+type rendition struct {
+	Label   string
+	Codec   string // ffmpeg encoder
 	Bitrate string
 	Width   int
 	Height  int
-}{
-	"360p":  {"500k", 480, 360},
-	"480p":  {"1000k", 858, 480},
-	"720p":  {"2000k", 1280, 720},
-	"1080p": {"4000k", 1920, 1080},
+
+	// Measured from the encoded output by analyzeRendition.
+	Segments         []util.MediaSegment
+	PeakBandwidth    int
+	AverageBandwidth int
+	Codecs           string
+	FrameRate        float64
+
+	// Set by generateIFramePlaylist when the profile asks for trick play.
+	IFramePlaylist  string
+	IFrameBandwidth int
+}
+
+// buildLadder picks the rungs that fit the source without upscaling and sizes
+// them to the source display aspect ratio. A source smaller than the lowest
+// rung is encoded once at its own size.
+func buildLadder(info *util.MediaInfo, ladder []entity.LadderRung) []rendition {
+	width, height := info.DisplaySize()
+	short, long := min(width, height), max(width, height)
+	portrait := height > width
+
+	scale := func(size int) (int, int) {
+		scaledLong := evenDimension(float64(size) * float64(long) / float64(short))
+		if portrait {
+			return evenDimension(float64(size)), scaledLong
+		}
+		return scaledLong, evenDimension(float64(size))
+	}
+
+	renditions := make([]rendition, 0, len(ladder))
+	for _, rung := range ladder {
+		if rung.Size > short {
+			break
+		}
+		w, h := scale(rung.Size)
+		renditions = append(renditions, rendition{Label: rung.Label, Codec: rung.Codec, Bitrate: rung.Bitrate, Width: w, Height: h})
+	}
+
+	if len(renditions) == 0 {
+		w, h := scale(short)
+		renditions = append(renditions, rendition{
+			Label:   fmt.Sprintf("%dp", evenDimension(float64(short))),
+			Codec:   ladder[0].Codec,
+			Bitrate: ladder[0].Bitrate,
+			Width:   w,
+			Height:  h,
+		})
+	}
+
+	return renditions
+}
+
+// evenDimension rounds to the nearest even number, as required by yuv420p.
+func evenDimension(size float64) int {
+	return max(int(math.Round(size/2))*2, 2)
 }
 
 func ResolvePath(parts ...string) string {
@@ -48,141 +127,682 @@ func ResolvePath(parts ...string) string {
 	return filepath.Join(append([]string{cwd}, parts...)...)
 }
 
+// ValidateRequest checks an encode request before it is queued and assigns
+// it a server-generated video ID, so uploads with the same file name never
+// share storage.
+func (u *encodeUseCase) ValidateRequest(ctx context.Context, req *model.EncodeRequest) error {
+	if err := validateFilename(req.OriginalFilename); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	profile, err := u.profileRepository.GetByName(req.Profile)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Unknown encoding profile %q", req.Profile))
+	}
+
+	if err := u.checkEncoders(profile); err != nil {
+		return fiber.NewError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	req.Profile = profile.Name
+	if req.VideoID == "" {
+		req.VideoID = uuid.NewString()
+	}
+	return nil
+}
+
+const maxFilenameLength = 255
+
+// validateFilename rejects client file names that are not a single, printable
+// path element. The name is only kept as metadata, but it still ends up in
+// titles and logs.
+func validateFilename(name string) error {
+	if name == "" || len(name) > maxFilenameLength || !utf8.ValidString(name) {
+		return fmt.Errorf("Invalid file name, it must be between 1 and %d bytes of UTF-8", maxFilenameLength)
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("Invalid file name %q, it must not contain a path", name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("Invalid file name %q, it must not contain control characters", name)
+		}
+	}
+	return nil
+}
+
+// PlaybackURLs lists where a queued video will be playable once encoded.
+func (u *encodeUseCase) PlaybackURLs(ctx context.Context, req *model.EncodeRequest) *model.PlaybackURLs {
+	base := fmt.Sprintf("%s/videos/%s", req.APIServer, url.PathEscape(req.VideoID))
+	urls := &model.PlaybackURLs{
+		HLS:        base + "/playlists/master.m3u8",
+		Thumbnails: base + "/thumbnails.vtt",
+	}
+
+	if profile, err := u.profileRepository.GetByName(req.Profile); err == nil && profile.DASH {
+		urls.DASH = base + "/manifests/" + dashManifestName
+	}
+	return urls
+}
+
+// checkEncoders reports the first encoder of the profile that the local
+// ffmpeg build does not provide.
+func (u *encodeUseCase) checkEncoders(profile *entity.EncodingProfile) error {
+	for _, encoder := range profile.Encoders() {
+		if !u.encoders[encoder] {
+			return fmt.Errorf("Encoding profile %q requires the %s encoder, which this ffmpeg build does not provide", profile.Name, encoder)
+		}
+	}
+	return nil
+}
+
 func (u *encodeUseCase) EncodeAndUpload(ctx context.Context, req *model.EncodeRequest) error {
-	req.InputPath = ResolvePath("usecase", "tmp", fmt.Sprintf("%s", req.VideoID))
-	req.OutputDir = ResolvePath("usecase", "tmp", "output", req.VideoID)
+	req.InputPath = u.sourcePath(req.VideoID)
+	req.OutputDir = filepath.Join(u.sourceDir, "output", req.VideoID)
 	req.S3Prefix = fmt.Sprintf("courses/%s", req.VideoID)
 
 	log.Print("input : ", req.InputPath)
 	log.Print("output : ", req.OutputDir)
 
+	if err := u.startVideo(ctx, req); err != nil {
+		log.Printf("[USECASE][StartVideo %s] %v", req.VideoID, err)
+		return u.failJob(ctx, req, fmt.Errorf("record video: %w", err))
+	}
+
+	if req.SourceKey != "" {
+		defer removeSource(context.WithoutCancel(ctx), u.objectStore, req.SourceKey)
+		u.updateJob(ctx, req, entity.JobStatusDownloading, "")
+		if err := downloadSource(ctx, u.objectStore, req.SourceKey, req.InputPath); err != nil {
+			log.Printf("[USECASE][DownloadSource %s] %v", req.SourceKey, err)
+			return u.failJob(ctx, req, fmt.Errorf("download source: %w", err))
+		}
+		defer os.Remove(req.InputPath)
+	}
+
+	if req.SourceURL != "" {
+		u.updateJob(ctx, req, entity.JobStatusDownloading, "")
+		if err := u.importer.download(ctx, req.SourceURL, req.InputPath); err != nil {
+			log.Printf("[USECASE][ImportSource %s] %v", req.SourceURL, err)
+			return u.failJob(ctx, req, fmt.Errorf("import source: %w", err))
+		}
+		defer os.Remove(req.InputPath)
+	}
+
+	profile, err := u.profileRepository.GetByName(req.Profile)
+	if err != nil {
+		log.Printf("[USECASE][GetProfile %s] %v", req.Profile, err)
+		return u.failJob(ctx, req, fmt.Errorf("profile %q: %w", req.Profile, err))
+	}
+
+	if err := u.checkEncoders(profile); err != nil {
+		log.Printf("[USECASE][CheckEncoders] %v", err)
+		return u.failJob(ctx, req, err)
+	}
+
 	if err := os.MkdirAll(req.OutputDir, 0755); err != nil {
 		log.Printf("[USECASE][MkdirAll] %v", err)
-		return fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+		return u.failJob(ctx, req, fmt.Errorf("create output dir: %w", err))
+	}
+
+	u.updateJob(ctx, req, entity.JobStatusProbing, "")
+	info, err := util.ProbeMedia(ctx, req.InputPath)
+	if err != nil {
+		log.Printf("[USECASE][ProbeMedia] %v", err)
+		return u.failJob(ctx, req, fmt.Errorf("probe source: %w", err))
 	}
 
-	for label, res := range resolutions {
-		if err := u.encodeVariant(ctx, req, label, res.Width, res.Height, res.Bitrate); err != nil {
-			log.Printf("[USECASE][EncodeVariant %s] %v", label, err)
-			return fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+	if req.JobID != "" {
+		if err := u.jobUseCase.RecordProbe(ctx, req.JobID, info); err != nil {
+			log.Printf("[USECASE][RecordProbe %s] %v", req.JobID, err)
 		}
 	}
+	u.updateVideo(ctx, req, func(video *entity.Video) {
+		video.DurationMs = info.Duration.Milliseconds()
+	})
+
+	renditions := buildLadder(info, profile.Ladder)
+
+	var audio []audioRendition
+	if profile.SeparateAudio {
+		audio = buildAudioRenditions(info)
+	}
+
+	if profile.SinglePass {
+		u.updateJob(ctx, req, entity.JobStatusEncoding, singlePassVariant)
+		onProgress := u.progressReporter(req, singlePassVariant, 0, 1, info.Duration)
+		if err := u.encodeSinglePass(ctx, req, profile, info, renditions, audio, onProgress); err != nil {
+			log.Printf("[USECASE][EncodeSinglePass] %v", err)
+			return u.failJob(ctx, req, fmt.Errorf("encode single pass: %w", err))
+		}
+	} else {
+		steps := len(renditions) + len(audio)
+		for i, res := range renditions {
+			u.updateJob(ctx, req, entity.JobStatusEncoding, res.Label)
+			onProgress := u.progressReporter(req, res.Label, i, steps, info.Duration)
+			if err := u.encodeVariant(ctx, req, profile, info, res, onProgress); err != nil {
+				log.Printf("[USECASE][EncodeVariant %s] %v", res.Label, err)
+				return u.failJob(ctx, req, fmt.Errorf("encode %s: %w", res.Label, err))
+			}
+		}
+
+		for i, track := range audio {
+			u.updateJob(ctx, req, entity.JobStatusEncoding, track.Label)
+			onProgress := u.progressReporter(req, track.Label, len(renditions)+i, steps, info.Duration)
+			if err := u.encodeAudio(ctx, req, profile, track, onProgress); err != nil {
+				log.Printf("[USECASE][EncodeAudio %s] %v", track.Label, err)
+				return u.failJob(ctx, req, fmt.Errorf("encode %s: %w", track.Label, err))
+			}
+		}
+	}
+
+	if err := verifySegmentAlignment(req.OutputDir, renditions, info.FPS); err != nil {
+		log.Printf("[USECASE][VerifySegmentAlignment] %v", err)
+		return u.failJob(ctx, req, fmt.Errorf("segments not keyframe aligned: %w", err))
+	}
+
+	// Renditions are analyzed before encryption so ffprobe can read the segments.
+	for i := range renditions {
+		if err := analyzeRendition(ctx, req.OutputDir, &renditions[i]); err != nil {
+			log.Printf("[USECASE][AnalyzeRendition %s] %v", renditions[i].Label, err)
+			return u.failJob(ctx, req, fmt.Errorf("analyze %s: %w", renditions[i].Label, err))
+		}
 
-	if err := generateMasterPlaylist(req.OutputDir); err != nil {
+		if profile.IFrames {
+			if err := generateIFramePlaylist(ctx, req.OutputDir, profile, &renditions[i]); err != nil {
+				log.Printf("[USECASE][GenerateIFramePlaylist %s] %v", renditions[i].Label, err)
+				return u.failJob(ctx, req, fmt.Errorf("i-frame playlist %s: %w", renditions[i].Label, err))
+			}
+		}
+
+		if profile.Encryption {
+			if err := u.encryptAndStoreKeys(ctx, req, renditions[i].Label, profile.KeyRotation); err != nil {
+				log.Printf("[USECASE][EncryptVariant %s] %v", renditions[i].Label, err)
+				return u.failJob(ctx, req, fmt.Errorf("encrypt %s: %w", renditions[i].Label, err))
+			}
+		}
+	}
+
+	for i := range audio {
+		if err := analyzeAudioRendition(ctx, req.OutputDir, &audio[i]); err != nil {
+			log.Printf("[USECASE][AnalyzeAudioRendition %s] %v", audio[i].Label, err)
+			return u.failJob(ctx, req, fmt.Errorf("analyze %s: %w", audio[i].Label, err))
+		}
+
+		if profile.Encryption {
+			if err := u.encryptAndStoreKeys(ctx, req, audio[i].Label, profile.KeyRotation); err != nil {
+				log.Printf("[USECASE][EncryptVariant %s] %v", audio[i].Label, err)
+				return u.failJob(ctx, req, fmt.Errorf("encrypt %s: %w", audio[i].Label, err))
+			}
+		}
+	}
+
+	if err := generateMasterPlaylist(req.OutputDir, renditions, audio); err != nil {
 		log.Printf("[USECASE][GenerateMasterPlaylist] %v", err)
-		return fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+		return u.failJob(ctx, req, fmt.Errorf("generate master playlist: %w", err))
 	}
 
-	if err := u.uploadDirToS3(ctx, req); err != nil {
-		log.Printf("[USECASE][UploadDir] %v", err)
-		return fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+	if profile.DASH {
+		if err := generateDashManifest(req.OutputDir, renditions, audio, profile.SegmentDuration); err != nil {
+			log.Printf("[USECASE][GenerateDashManifest] %v", err)
+			return u.failJob(ctx, req, fmt.Errorf("generate dash manifest: %w", err))
+		}
 	}
 
-	return util.DeleteDir(req.OutputDir)
-}
+	u.updateJob(ctx, req, entity.JobStatusEncoding, thumbnailVariant)
+	if err := u.generateThumbnails(ctx, req, info); err != nil {
+		log.Printf("[USECASE][GenerateThumbnails] %v", err)
+		return u.failJob(ctx, req, fmt.Errorf("generate thumbnails: %w", err))
+	}
 
-func (u *encodeUseCase) encodeVariant(ctx context.Context, req *model.EncodeRequest, label string, width, height int, bitrate string) error {
-	playlist := filepath.Join(req.OutputDir, fmt.Sprintf("%s.m3u8", label))
-	segmentPattern := filepath.Join(req.OutputDir, fmt.Sprintf("%s_%%03d.ts", label))
+	u.updateJob(ctx, req, entity.JobStatusUploading, "")
+	if err := newUploader(u.objectStore).uploadDir(ctx, req.OutputDir, req.S3Prefix); err != nil {
+		log.Printf("[USECASE][UploadDir] %v", err)
+		return u.failJob(ctx, req, fmt.Errorf("upload output: %w", err))
+	}
 
-	keyBin := make([]byte, 16)
-	if _, err := rand.Read(keyBin); err != nil {
-		return fmt.Errorf("generate key: %w", err)
+	if err := util.DeleteDir(req.OutputDir); err != nil {
+		return u.failJob(ctx, req, fmt.Errorf("delete output dir: %w", err))
 	}
 
-	keyPath := filepath.Join(req.OutputDir, fmt.Sprintf("enc_%s.key", label))
-	if err := os.WriteFile(keyPath, keyBin, 0644); err != nil {
-		return fmt.Errorf("write key file: %w", err)
+	u.updateVideo(ctx, req, func(video *entity.Video) {
+		video.Status = entity.VideoStatusReady
+		video.Renditions = videoRenditions(renditions)
+	})
+	u.updateJob(ctx, req, entity.JobStatusDone, "")
+	return nil
+}
+
+// updateJob records a job transition. Tracking failures are logged only so
+// that they never abort an otherwise healthy encode.
+func (u *encodeUseCase) updateJob(ctx context.Context, req *model.EncodeRequest, status, variant string) {
+	if req.JobID == "" {
+		return
 	}
+	if err := u.jobUseCase.UpdateStatus(ctx, req.JobID, status, variant, nil); err != nil {
+		log.Printf("[USECASE][UpdateJob %s] %v", req.JobID, err)
+	}
+}
 
-	iv := make([]byte, 16)
-	rand.Read(iv)
-	ivHex := hex.EncodeToString(iv)
+// progressReporter converts ffmpeg progress of one variant into an aggregate
+// job progress event. Variants are weighted equally.
+func (u *encodeUseCase) progressReporter(req *model.EncodeRequest, label string, index, total int, duration time.Duration) func(util.FFmpegProgress) {
+	return func(p util.FFmpegProgress) {
+		if req.JobID == "" {
+			return
+		}
 
-	keyUriPlaceholder := fmt.Sprintf("__REPLACE_ME_URI_%s__", label)
-	keyInfoPath := filepath.Join(req.OutputDir, fmt.Sprintf("keyinfo_%s.txt", label))
-	keyInfoContent := fmt.Sprintf("%s\n%s\n%s", keyUriPlaceholder, keyPath, ivHex)
+		variantPercent := 0.0
+		if p.Done {
+			variantPercent = 100
+		} else if duration > 0 {
+			variantPercent = min(float64(p.OutTime)/float64(duration)*100, 100)
+		}
+
+		u.jobUseCase.ReportProgress(&model.JobProgressEvent{
+			JobID:          req.JobID,
+			Status:         entity.JobStatusEncoding,
+			Variant:        label,
+			OutTimeMs:      p.OutTime.Milliseconds(),
+			FPS:            p.FPS,
+			Speed:          p.Speed,
+			VariantPercent: variantPercent,
+			Percent:        (float64(index)*100 + variantPercent) / float64(total),
+		})
+	}
+}
 
-	if err := os.WriteFile(keyInfoPath, []byte(keyInfoContent), 0644); err != nil {
-		return fmt.Errorf("write keyinfo: %w", err)
+// failJob marks the job as failed with the cause and returns the error
+// exposed to callers.
+func (u *encodeUseCase) failJob(ctx context.Context, req *model.EncodeRequest, cause error) error {
+	if req.JobID != "" {
+		if err := u.jobUseCase.UpdateStatus(ctx, req.JobID, entity.JobStatusFailed, "", cause); err != nil {
+			log.Printf("[USECASE][FailJob %s] %v", req.JobID, err)
+		}
 	}
-	defer os.Remove(keyInfoPath) // optional cleanup
+	u.updateVideo(ctx, req, func(video *entity.Video) {
+		video.Status = entity.VideoStatusFailed
+	})
+	return fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+}
 
-	cmd := exec.CommandContext(ctx, "ffmpeg",
+func (u *encodeUseCase) encodeVariant(ctx context.Context, req *model.EncodeRequest, profile *entity.EncodingProfile, info *util.MediaInfo, res rendition, onProgress func(util.FFmpegProgress)) error {
+	playlist := filepath.Join(req.OutputDir, fmt.Sprintf("%s.m3u8", res.Label))
+
+	args := []string{
 		"-i", req.InputPath,
-		"-vf", fmt.Sprintf("scale=w=%d:h=%d", width, height),
-		"-c:a", "aac",
-		"-b:v", bitrate,
-		"-hls_time", "4",
-		"-hls_playlist_type", "vod",
-		"-hls_segment_filename", segmentPattern,
-		"-hls_key_info_file", keyInfoPath,
+		"-vf", fmt.Sprintf("scale=w=%d:h=%d,setsar=1", res.Width, res.Height),
+		"-c:v", res.Codec,
+		"-b:v", res.Bitrate,
+	}
+	args = append(args, codecArgs(res.Codec, ":v")...)
+	if profile.Preset != "" {
+		args = append(args, "-preset", profile.Preset)
+	}
+	args = append(args, keyframeArgs(profile, info)...)
+	if profile.SeparateAudio {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-c:a", "aac")
+		if profile.AudioBitrate != "" {
+			args = append(args, "-b:a", profile.AudioBitrate)
+		}
+	}
+	args = append(args, segmentArgs(profile, req.OutputDir, res.Label)...)
+	args = append(args,
+		"-progress", "pipe:1",
+		"-nostats",
 		playlist,
 	)
+
+	return runFFmpeg(ctx, args, onProgress)
+}
+
+// segmentArgs configures the HLS muxer for the profile segment format. name is
+// the rendition label, or %v when the muxer writes several variants.
+func segmentArgs(profile *entity.EncodingProfile, outputDir, name string) []string {
+	args := []string{
+		"-hls_time", strconv.Itoa(profile.SegmentDuration),
+		"-hls_playlist_type", "vod",
+	}
+
+	if profile.SegmentFormat == entity.SegmentFormatFMP4 {
+		return append(args,
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", fmt.Sprintf("%s_init.mp4", name),
+			"-hls_segment_filename", filepath.Join(outputDir, fmt.Sprintf("%s_%%03d.m4s", name)),
+		)
+	}
+
+	return append(args, "-hls_segment_filename", filepath.Join(outputDir, fmt.Sprintf("%s_%%03d.ts", name)))
+}
+
+// codecArgs returns encoder specific options for the video stream selected
+// by spec, e.g. ":v" or ":v:2": Apple compatible HEVC tagging and disabled
+// scene-cut keyframes for encoders that ignore -sc_threshold.
+func codecArgs(codec, spec string) []string {
+	switch codec {
+	case "libx265":
+		return []string{"-tag" + spec, "hvc1", "-x265-params" + spec, "scenecut=0:open-gop=0"}
+	case "libsvtav1":
+		return []string{"-svtav1-params" + spec, "scd=0"}
+	}
+
+	if entity.CodecFamily(codec) == entity.CodecFamilyHEVC {
+		return []string{"-tag" + spec, "hvc1"}
+	}
+	return nil
+}
+
+// keyframeArgs forces a keyframe at every segment boundary and disables
+// scene-cut keyframes so that all renditions are cut at the same instants.
+// The GOP defaults to the segment duration.
+func keyframeArgs(profile *entity.EncodingProfile, info *util.MediaInfo) []string {
+	args := []string{
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", profile.SegmentDuration),
+		"-sc_threshold", "0",
+	}
+
+	if info.FPS > 0 {
+		gopSeconds := profile.GOPSeconds
+		if gopSeconds <= 0 {
+			gopSeconds = float64(profile.SegmentDuration)
+		}
+
+		gop := strconv.Itoa(max(int(math.Round(gopSeconds*info.FPS)), 1))
+		args = append(args, "-g", gop, "-keyint_min", gop)
+	}
+
+	return args
+}
+
+// verifySegmentAlignment checks that every rendition has the same segment
+// boundaries as the first one, within half a frame.
+func verifySegmentAlignment(outputDir string, renditions []rendition, fps float64) error {
+	tolerance := 0.02
+	if fps > 0 {
+		tolerance = 0.5 / fps
+	}
+
+	var (
+		reference      []float64
+		referenceLabel string
+	)
+	for _, res := range renditions {
+		data, err := os.ReadFile(filepath.Join(outputDir, fmt.Sprintf("%s.m3u8", res.Label)))
+		if err != nil {
+			return fmt.Errorf("read m3u8 file: %w", err)
+		}
+
+		segments, err := util.ParseMediaPlaylist(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", res.Label, err)
+		}
+
+		boundaries := make([]float64, len(segments))
+		elapsed := 0.0
+		for i, segment := range segments {
+			elapsed += segment.Duration
+			boundaries[i] = elapsed
+		}
+
+		if reference == nil {
+			reference, referenceLabel = boundaries, res.Label
+			continue
+		}
+
+		if len(boundaries) != len(reference) {
+			return fmt.Errorf("%s has %d segments but %s has %d", res.Label, len(boundaries), referenceLabel, len(reference))
+		}
+		for i := range boundaries {
+			if math.Abs(boundaries[i]-reference[i]) > tolerance {
+				return fmt.Errorf("segment %d of %s ends at %.3fs but %s ends at %.3fs", i, res.Label, boundaries[i], referenceLabel, reference[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// runFFmpeg runs ffmpeg with -progress output on stdout and forwards every
+// progress block to onProgress.
+func runFFmpeg(ctx context.Context, args []string, onProgress func(util.FFmpegProgress)) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
 
-	if err := cmd.Run(); err != nil {
+	progress, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("ffmpeg stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg start failed: %w", err)
+	}
+
+	if err := util.ReadFFmpegProgress(progress, onProgress); err != nil {
+		log.Printf("[USECASE][ReadFFmpegProgress] %v", err)
+		io.Copy(io.Discard, progress) // keep ffmpeg from blocking on a full pipe
+	}
+
+	if err := cmd.Wait(); err != nil {
 		return fmt.Errorf("ffmpeg run failed: %w", err)
 	}
 
-	return replaceKeyUriInM3U8(req.OutputDir, label, req.APIServer, req.VideoID, keyUriPlaceholder)
+	return nil
 }
 
-func replaceKeyUriInM3U8(outputDir, label, apiServer, videoID, placeholder string) error {
-	m3u8Path := filepath.Join(outputDir, fmt.Sprintf("%s.m3u8", label))
-	data, err := os.ReadFile(m3u8Path)
+// encryptAndStoreKeys encrypts a rendition and hands its content keys to the
+// key store, so they never reach the media bucket.
+func (u *encodeUseCase) encryptAndStoreKeys(ctx context.Context, req *model.EncodeRequest, label string, rotation int) error {
+	keys, err := encryptVariant(req, label, rotation)
 	if err != nil {
-		return fmt.Errorf("read m3u8 file: %w", err)
+		return err
 	}
 
-	finalKeyUri := fmt.Sprintf("%s/videos/%s/keys/enc_%s.key", strings.TrimSuffix(apiServer, "/"), videoID, label)
-	log.Println("[DEBUG] Final Key URI:", finalKeyUri)
-	updated := bytes.ReplaceAll(data, []byte(placeholder), []byte(finalKeyUri))
+	for name, key := range keys {
+		if err := u.keyStore.Put(ctx, req.VideoID, name, key); err != nil {
+			return fmt.Errorf("store key %s: %w", name, err)
+		}
+	}
 
-	return os.WriteFile(m3u8Path, updated, 0644)
+	return nil
 }
 
-func generateMasterPlaylist(outputDir string) error {
-	var builder strings.Builder
-	builder.WriteString("#EXTM3U\n")
+// encryptVariant encrypts every segment of a variant playlist with AES-128
+// and adds the matching #EXT-X-KEY tags. With a rotation period a fresh key
+// is generated every rotation segments, otherwise one key covers the whole
+// rendition. It returns the keys by file name. Encrypting after the encode,
+// rather than through the muxer, gives every rendition its own keys in both
+// encode modes.
+func encryptVariant(req *model.EncodeRequest, label string, rotation int) (map[string][]byte, error) {
+	playlistPath := filepath.Join(req.OutputDir, fmt.Sprintf("%s.m3u8", label))
+	data, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return nil, fmt.Errorf("read m3u8 file: %w", err)
+	}
 
-	for label, res := range resolutions {
-		bandwidth := map[string]string{
-			"360p":  "800000",
-			"480p":  "1400000",
-			"720p":  "2800000",
-			"1080p": "5000000",
-		}[label]
-		builder.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%s,RESOLUTION=%dx%d\n", bandwidth, res.Width, res.Height))
-		builder.WriteString(fmt.Sprintf("%s.m3u8\n", label))
+	var (
+		key, iv []byte
+		segment int
+	)
+	keys := make(map[string][]byte)
+
+	lines := strings.Split(string(data), "\n")
+	updated := make([]string, 0, len(lines)+1)
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#EXTINF") && (key == nil || rotation > 0 && segment%rotation == 0) {
+			name := keyName(label, rotation, segment)
+			key, iv, err = generateKey()
+			if err != nil {
+				return nil, err
+			}
+			keys[name] = key
+			updated = append(updated, fmt.Sprintf(`#EXT-X-KEY:METHOD=AES-128,URI="%s",IV=0x%s`, keyURI(req.APIServer, req.VideoID, name), hex.EncodeToString(iv)))
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			if key == nil {
+				return nil, fmt.Errorf("segment %s has no #EXTINF", trimmed)
+			}
+			if err := util.EncryptFileAES128(filepath.Join(req.OutputDir, trimmed), key, iv); err != nil {
+				return nil, err
+			}
+			segment++
+		}
+		updated = append(updated, line)
 	}
 
-	masterPath := filepath.Join(outputDir, "master.m3u8")
-	return os.WriteFile(masterPath, []byte(builder.String()), 0644)
+	if err := os.WriteFile(playlistPath, []byte(strings.Join(updated, "\n")), 0644); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
 }
 
-func (u *encodeUseCase) uploadDirToS3(ctx context.Context, req *model.EncodeRequest) error {
-	return filepath.WalkDir(req.OutputDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
-			return nil
-		}
+// keyName names the key of the segment at index: enc_<label>.key without
+// rotation, enc_<label>_<period>.key with it.
+func keyName(label string, rotation, index int) string {
+	if rotation <= 0 {
+		return fmt.Sprintf("enc_%s.key", label)
+	}
+	return fmt.Sprintf("enc_%s_%03d.key", label, index/rotation)
+}
 
-		relPath, err := filepath.Rel(req.OutputDir, path)
-		if err != nil {
-			return fmt.Errorf("rel path: %w", err)
+// generateKey creates a random AES-128 key and IV.
+func generateKey() ([]byte, []byte, error) {
+	keyBin := make([]byte, 16)
+	if _, err := rand.Read(keyBin); err != nil {
+		return nil, nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	iv := make([]byte, 16)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, fmt.Errorf("generate iv: %w", err)
+	}
+
+	return keyBin, iv, nil
+}
+
+func keyURI(apiServer, videoID, name string) string {
+	return fmt.Sprintf("%s/videos/%s/keys/%s", strings.TrimSuffix(apiServer, "/"), videoID, name)
+}
+
+// playlistStats is what analyzePlaylist measures from an encoded playlist.
+type playlistStats struct {
+	Segments         []util.MediaSegment
+	PeakBandwidth    int
+	AverageBandwidth int
+	Codecs           string
+	FrameRate        float64
+}
+
+// analyzePlaylist measures the peak and average bitrate of the produced
+// segments and reads the codecs and frame rate back from the playlist, which
+// works for both MPEG-TS and fMP4 segments.
+func analyzePlaylist(ctx context.Context, outputDir, label string) (*playlistStats, error) {
+	playlistPath := filepath.Join(outputDir, fmt.Sprintf("%s.m3u8", label))
+	data, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return nil, fmt.Errorf("read m3u8 file: %w", err)
+	}
+
+	segments, err := util.ParseMediaPlaylist(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("playlist has no segments")
+	}
+
+	stats := &playlistStats{Segments: segments}
+	stats.PeakBandwidth, stats.AverageBandwidth, err = util.PlaylistBandwidth(outputDir, segments)
+	if err != nil {
+		return nil, err
+	}
+
+	codecs, err := util.ProbeOutputCodecs(ctx, playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	stats.Codecs = codecs.Codecs
+	stats.FrameRate = codecs.FrameRate
+
+	return stats, nil
+}
+
+func analyzeRendition(ctx context.Context, outputDir string, res *rendition) error {
+	stats, err := analyzePlaylist(ctx, outputDir, res.Label)
+	if err != nil {
+		return err
+	}
+
+	res.Segments = stats.Segments
+	res.PeakBandwidth = stats.PeakBandwidth
+	res.AverageBandwidth = stats.AverageBandwidth
+	res.Codecs = stats.Codecs
+	res.FrameRate = stats.FrameRate
+	return nil
+}
+
+// generateMasterPlaylist writes master.m3u8. With separate audio renditions
+// every video variant references the audio group, its bandwidth includes the
+// largest audio rendition, and an audio-only variant is listed last. I-frame
+// playlists are advertised after the variants they belong to.
+func generateMasterPlaylist(outputDir string, renditions []rendition, audio []audioRendition) error {
+	var builder strings.Builder
+	builder.WriteString("#EXTM3U\n")
+
+	var (
+		audioPeak, audioAverage int
+		audioCodecs, audioAttr  string
+		defaultAudio            *audioRendition
+	)
+	for i := range audio {
+		track := &audio[i]
+		builder.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"%s\",NAME=\"%s\"", audioGroupID, track.Name))
+		if track.Language != "" {
+			builder.WriteString(fmt.Sprintf(",LANGUAGE=\"%s\"", track.Language))
+		}
+		if track.Default {
+			builder.WriteString(",DEFAULT=YES")
+			defaultAudio = track
+		} else {
+			builder.WriteString(",DEFAULT=NO")
 		}
+		builder.WriteString(",AUTOSELECT=YES")
+		if track.Channels > 0 {
+			builder.WriteString(fmt.Sprintf(",CHANNELS=\"%d\"", track.Channels))
+		}
+		builder.WriteString(fmt.Sprintf(",URI=\"%s.m3u8\"\n", track.Label))
 
-		key := fmt.Sprintf("%s/%s", req.S3Prefix, relPath)
-		if strings.HasSuffix(relPath, ".key") {
-			key = fmt.Sprintf("%s/secrets/%s", req.S3Prefix, filepath.Base(relPath))
+		audioPeak = max(audioPeak, track.PeakBandwidth)
+		audioAverage = max(audioAverage, track.AverageBandwidth)
+	}
+	if defaultAudio != nil {
+		audioCodecs = "," + defaultAudio.Codecs
+		audioAttr = fmt.Sprintf(",AUDIO=\"%s\"", audioGroupID)
+	}
+
+	for _, res := range renditions {
+		builder.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,RESOLUTION=%dx%d", res.PeakBandwidth+audioPeak, res.AverageBandwidth+audioAverage, res.Width, res.Height))
+		if res.FrameRate > 0 {
+			builder.WriteString(fmt.Sprintf(",FRAME-RATE=%.3f", res.FrameRate))
 		}
+		builder.WriteString(fmt.Sprintf(",CODECS=\"%s%s\"%s\n", res.Codecs, audioCodecs, audioAttr))
+		builder.WriteString(fmt.Sprintf("%s.m3u8\n", res.Label))
+	}
 
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("read %s: %w", path, err)
+	for _, res := range renditions {
+		if res.IFramePlaylist == "" {
+			continue
 		}
+		builder.WriteString(fmt.Sprintf("#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\",URI=\"%s\"\n", res.IFrameBandwidth, res.Width, res.Height, videoCodecs(res.Codecs), res.IFramePlaylist))
+	}
 
-		return u.minio.UploadToS3(ctx, u.minio.GetBucketName(), key, data)
-	})
+	if defaultAudio != nil {
+		builder.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\"%s\n", defaultAudio.PeakBandwidth, defaultAudio.AverageBandwidth, defaultAudio.Codecs, audioAttr))
+		builder.WriteString(fmt.Sprintf("%s.m3u8\n", defaultAudio.Label))
+	}
+
+	masterPath := filepath.Join(outputDir, "master.m3u8")
+	return os.WriteFile(masterPath, []byte(builder.String()), 0644)
 }
@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// startVideo records the video being encoded. Encoding a video again keeps
+// its creation time and title unless the request sets a new title.
+func (u *encodeUseCase) startVideo(ctx context.Context, req *model.EncodeRequest) error {
+	now := time.Now()
+	_, err := u.videoRepository.Update(ctx, req.VideoID, func(video *entity.Video) error {
+		if req.Title != "" {
+			video.Title = req.Title
+		}
+		if req.Owner != "" {
+			video.Owner = req.Owner
+		}
+		video.Dir = req.S3Prefix
+		video.Status = entity.VideoStatusProcessing
+		video.UpdatedAt = now
+		return nil
+	})
+	if !errors.Is(err, repository.ErrVideoNotFound) {
+		return err
+	}
+
+	title := req.Title
+	if title == "" {
+		title = strings.TrimSuffix(req.OriginalFilename, filepath.Ext(req.OriginalFilename))
+	}
+	if title == "" {
+		title = req.VideoID
+	}
+
+	return u.videoRepository.Save(ctx, &entity.Video{
+		ID:               req.VideoID,
+		Title:            title,
+		Owner:            req.Owner,
+		OriginalFilename: req.OriginalFilename,
+		Dir:              req.S3Prefix,
+		Status:           entity.VideoStatusProcessing,
+		Renditions:       []entity.VideoRendition{},
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	})
+}
+
+// errInterrupted is the cause recorded on jobs a restart interrupted.
+var errInterrupted = errors.New("interrupted by a restart, upload the video again")
+
+// RecoverInterrupted fails the jobs and videos a previous run left
+// unfinished, so that their status is accurate and the videos can be
+// deleted, and removes the sources the jobs were handed. It must run before
+// the workers start.
+func (u *encodeUseCase) RecoverInterrupted(ctx context.Context) error {
+	jobs, err := u.jobUseCase.FailUnfinished(ctx, errInterrupted)
+	if err != nil {
+		return fmt.Errorf("fail interrupted jobs: %w", err)
+	}
+	for _, job := range jobs {
+		if err := os.Remove(u.sourcePath(job.VideoID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[USECASE][RemoveSource %s] %v", job.VideoID, err)
+		}
+		removeSource(ctx, u.objectStore, IncomingPrefix+job.VideoID)
+	}
+
+	videos, err := u.videoRepository.List(ctx)
+	if err != nil {
+		return fmt.Errorf("list videos: %w", err)
+	}
+
+	failed := 0
+	for _, video := range videos {
+		if video.Status != entity.VideoStatusProcessing {
+			continue
+		}
+		_, err := u.videoRepository.Update(ctx, video.ID, func(video *entity.Video) error {
+			video.Status = entity.VideoStatusFailed
+			video.UpdatedAt = time.Now()
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("fail video %s: %w", video.ID, err)
+		}
+		failed++
+	}
+
+	if len(jobs) > 0 || failed > 0 {
+		log.Printf("Recovered %d interrupted jobs and %d videos", len(jobs), failed)
+	}
+	return nil
+}
+
+// updateVideo applies update to the video record. Like updateJob, failures
+// are logged only.
+func (u *encodeUseCase) updateVideo(ctx context.Context, req *model.EncodeRequest, update func(video *entity.Video)) {
+	_, err := u.videoRepository.Update(ctx, req.VideoID, func(video *entity.Video) error {
+		update(video)
+		video.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		log.Printf("[USECASE][UpdateVideo %s] %v", req.VideoID, err)
+	}
+}
+
+func videoRenditions(renditions []rendition) []entity.VideoRendition {
+	result := make([]entity.VideoRendition, 0, len(renditions))
+	for _, res := range renditions {
+		result = append(result, entity.VideoRendition{
+			Label:     res.Label,
+			Width:     res.Width,
+			Height:    res.Height,
+			Bandwidth: res.PeakBandwidth,
+			Codecs:    res.Codecs,
+		})
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"errors"
+	"ffmpeg-hls/repository"
+	"ffmpeg-hls/util"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTestDB opens a migrated database that lives as long as the test.
+// Legacy videos are backfilled from store.
+func openTestDB(t *testing.T, store util.ObjectStore) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := repository.Migrate(db, store); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestStore(t *testing.T) *util.LocalStore {
+	return util.NewLocalStore(t.TempDir(), "http://localhost", []byte("secret"))
+}
+
+// newTestEncodeUseCase returns an encode usecase that knows the default
+// profiles and reports every encoder they use as available, enough to
+// validate requests.
+func newTestEncodeUseCase(t *testing.T) *encodeUseCase {
+	t.Helper()
+
+	profileRepo, err := repository.NewProfileRepository("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := profileRepo.GetByName("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoders := make(map[string]bool)
+	for _, encoder := range profile.Encoders() {
+		encoders[encoder] = true
+	}
+	return &encodeUseCase{profileRepository: profileRepo, encoders: encoders}
+}
+
+func expectStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var fiberErr *fiber.Error
+	if !errors.As(err, &fiberErr) || fiberErr.Code != status {
+		t.Fatalf("got %v, want status %d", err, status)
+	}
+}
@@ -0,0 +1,151 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ImportUseCase encodes sources hosted elsewhere.
+type ImportUseCase interface {
+	// ImportVideo checks req and returns the encode request that downloads
+	// the source at req.URL. The download runs with the job, in the worker.
+	ImportVideo(ctx context.Context, req *model.ImportVideoRequest) (*model.EncodeRequest, error)
+}
+
+type importUseCase struct {
+	encodeUseCase EncodeUseCase
+}
+
+func NewImportUseCase(encodeUseCase EncodeUseCase) ImportUseCase {
+	return &importUseCase{encodeUseCase: encodeUseCase}
+}
+
+// sourceImporter downloads imported sources for the encoder.
+type sourceImporter struct {
+	client *http.Client
+	config util.ImportConfig
+}
+
+func newSourceImporter(config util.ImportConfig) *sourceImporter {
+	return &sourceImporter{
+		client: util.NewImportClient(config),
+		config: config,
+	}
+}
+
+// importContentTypes are accepted besides video/*. Object stores commonly
+// serve uploads without a more specific type.
+var importContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/mp4":          true,
+	"application/mxf":          true,
+}
+
+func (u *importUseCase) ImportVideo(ctx context.Context, req *model.ImportVideoRequest) (*model.EncodeRequest, error) {
+	source, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return nil, fiber.NewError(http.StatusBadRequest, "url must be an absolute http or https URL")
+	}
+
+	filename := req.Filename
+	if filename == "" {
+		filename = path.Base(source.Path)
+		if filename == "/" || filename == "." {
+			filename = source.Hostname()
+		}
+	}
+
+	encodeRequest := &model.EncodeRequest{
+		OriginalFilename: filename,
+		SourceURL:        source.String(),
+		Profile:          req.Profile,
+		Title:            req.Title,
+		Owner:            req.Owner,
+	}
+	if err := u.encodeUseCase.ValidateRequest(ctx, encodeRequest); err != nil {
+		return nil, err
+	}
+
+	return encodeRequest, nil
+}
+
+// download streams the source to path within the import timeout, refusing
+// anything that is not media or larger than the configured maximum.
+func (u *sourceImporter) download(ctx context.Context, source, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, u.config.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return fmt.Errorf("url must be an absolute http or https URL: %w", err)
+	}
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return importError(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Source responded with status %d", response.StatusCode)
+	}
+
+	contentType := response.Header.Get(fiber.HeaderContentType)
+	if !isImportContentType(contentType) {
+		return fmt.Errorf("Source content type %q is not a video", contentType)
+	}
+
+	if response.ContentLength > u.config.MaxSize {
+		return fmt.Errorf("Source exceeds the maximum size of %d bytes", u.config.MaxSize)
+	}
+
+	// One byte past the limit tells a source that is too large apart from one
+	// that is exactly at it.
+	written, err := saveSource(path, io.LimitReader(response.Body, u.config.MaxSize+1))
+	if err == nil && written > u.config.MaxSize {
+		os.Remove(path)
+		return fmt.Errorf("Source exceeds the maximum size of %d bytes", u.config.MaxSize)
+	}
+	if err != nil {
+		return importError(err)
+	}
+
+	return nil
+}
+
+// importError describes a failed download, wrapping the cause.
+func importError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, util.ErrImportAddressBlocked):
+		return fmt.Errorf("url must point to a public address: %w", err)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("Downloading the source timed out: %w", err)
+	}
+	return fmt.Errorf("Failed to download the source: %w", err)
+}
+
+func isImportContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "video/") || importContentTypes[mediaType]
+}
@@ -0,0 +1,154 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"ffmpeg-hls/util"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportVideo(t *testing.T) {
+	u := NewImportUseCase(newTestEncodeUseCase(t))
+	ctx := context.Background()
+
+	encodeRequest, err := u.ImportVideo(ctx, &model.ImportVideoRequest{URL: " https://example.com/media/lecture.mp4 ", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encodeRequest.VideoID == "" || encodeRequest.OriginalFilename != "lecture.mp4" || encodeRequest.Owner != "alice" || encodeRequest.SourceURL != "https://example.com/media/lecture.mp4" {
+		t.Fatalf("got %+v", encodeRequest)
+	}
+
+	for _, url := range []string{"ftp://example.com/a.mp4", "/media/lecture.mp4", "https://"} {
+		_, err := u.ImportVideo(ctx, &model.ImportVideoRequest{URL: url})
+		expectStatus(t, err, http.StatusBadRequest)
+	}
+}
+
+func TestImportDownload(t *testing.T) {
+	data := bytes.Repeat([]byte("video"), 200)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/media/lecture.mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write(data)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/large":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write(make([]byte, 4096))
+		case "/large-chunked":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			for i := 0; i < 4; i++ {
+				w.Write(make([]byte, 1024))
+				w.(http.Flusher).Flush()
+			}
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("Content-Type", "video/mp4")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	importer := newSourceImporter(util.ImportConfig{MaxSize: 2048, Timeout: 100 * time.Millisecond, AllowPrivateNetworks: true})
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	if err := importer.download(ctx, server.URL+"/media/lecture.mp4", filepath.Join(dir, "v1")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "v1"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("imported %d bytes, %v; want %d", len(got), err, len(data))
+	}
+
+	for _, test := range []struct {
+		path    string
+		message string
+	}{
+		{"/missing.mp4", "Source responded with status 404"},
+		{"/page", `Source content type "text/html" is not a video`},
+		{"/large", "Source exceeds the maximum size of 2048 bytes"},
+		{"/large-chunked", "Source exceeds the maximum size of 2048 bytes"},
+		{"/slow", "Downloading the source timed out"},
+	} {
+		err := importer.download(ctx, server.URL+test.path, filepath.Join(dir, "v2"))
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Fatalf("%s: got %v, want %q", test.path, err, test.message)
+		}
+	}
+
+	// Only the successful import is left in the encoder input.
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got %d files in the source dir, %v; want 1", len(entries), err)
+	}
+}
+
+func TestImportDownloadPrivateNetwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the private server")
+	}))
+	defer server.Close()
+
+	importer := newSourceImporter(util.ImportConfig{MaxSize: 2048, Timeout: time.Second})
+	err := importer.download(context.Background(), server.URL+"/a.mp4", filepath.Join(t.TempDir(), "v1"))
+	if !errors.Is(err, util.ErrImportAddressBlocked) {
+		t.Fatalf("got %v, want %v", err, util.ErrImportAddressBlocked)
+	}
+}
+
+func TestImportJobFailsOnDownload(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	store := newTestStore(t)
+	db := openTestDB(t, store)
+	jobUC := NewJobUseCase(repository.NewJobRepository(db))
+	videoRepo := repository.NewVideoRepository(db)
+	u := &encodeUseCase{
+		objectStore:     store,
+		jobUseCase:      jobUC,
+		videoRepository: videoRepo,
+		importer:        newSourceImporter(util.ImportConfig{MaxSize: 2048, Timeout: time.Second, AllowPrivateNetworks: true}),
+		sourceDir:       t.TempDir(),
+	}
+	ctx := context.Background()
+
+	req := &model.EncodeRequest{VideoID: "v1", OriginalFilename: "a.mp4", SourceURL: server.URL + "/a.mp4"}
+	job, err := jobUC.CreateJob(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.EncodeAndUpload(ctx, req); err == nil {
+		t.Fatal("encoded a source that could not be downloaded")
+	}
+
+	got, err := jobUC.GetJob(ctx, &model.GetJobRequest{JobID: job.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != entity.JobStatusFailed || !strings.Contains(got.Error, "Source responded with status 404") {
+		t.Fatalf("got job %+v, want it failed by the download", got)
+	}
+	if len(got.Events) < 2 || got.Events[len(got.Events)-2].Status != entity.JobStatusDownloading {
+		t.Fatalf("got events %+v, want the download recorded", got.Events)
+	}
+	video, err := videoRepo.GetByID(ctx, "v1")
+	if err != nil || video.Status != entity.VideoStatusFailed {
+		t.Fatalf("got video %+v (%v), want it failed", video, err)
+	}
+}
@@ -0,0 +1,294 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"ffmpeg-hls/util"
+	errorcode "ffmpeg-hls/util/error"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type JobUseCase interface {
+	CreateJob(ctx context.Context, req *model.EncodeRequest) (*model.JobResponse, error)
+	UpdateStatus(ctx context.Context, jobID, status, variant string, cause error) error
+	FailUnfinished(ctx context.Context, cause error) ([]*entity.Job, error)
+	RecordProbe(ctx context.Context, jobID string, info *util.MediaInfo) error
+	GetJob(ctx context.Context, req *model.GetJobRequest) (*model.JobResponse, error)
+	ListJobs(ctx context.Context, req *model.ListJobsRequest) ([]*model.JobResponse, error)
+	ReportProgress(event *model.JobProgressEvent)
+	Subscribe(jobID string) (<-chan *model.JobProgressEvent, func())
+}
+
+type jobUseCase struct {
+	// mu serializes read-modify-write cycles on job records.
+	mu            sync.Mutex
+	jobRepository repository.JobRepository
+
+	// Progress is kept in memory only; it is streamed, not persisted.
+	subMu       sync.Mutex
+	subscribers map[string]map[chan *model.JobProgressEvent]struct{}
+	latest      map[string]*model.JobProgressEvent
+}
+
+func NewJobUseCase(jobRepository repository.JobRepository) JobUseCase {
+	return &jobUseCase{
+		jobRepository: jobRepository,
+		subscribers:   make(map[string]map[chan *model.JobProgressEvent]struct{}),
+		latest:        make(map[string]*model.JobProgressEvent),
+	}
+}
+
+func (u *jobUseCase) CreateJob(ctx context.Context, req *model.EncodeRequest) (*model.JobResponse, error) {
+	now := time.Now()
+	job := &entity.Job{
+		ID:        uuid.NewString(),
+		VideoID:   req.VideoID,
+		Profile:   req.Profile,
+		Status:    entity.JobStatusQueued,
+		Events:    []entity.JobEvent{{Status: entity.JobStatusQueued, Time: now}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := u.jobRepository.Save(ctx, job); err != nil {
+		log.Printf("[USECASE][CreateJob] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+	}
+
+	req.JobID = job.ID
+	return toJobResponse(job), nil
+}
+
+// UpdateStatus appends a state transition to the job. A non-nil cause is
+// stored as the job error text.
+func (u *jobUseCase) UpdateStatus(ctx context.Context, jobID, status, variant string, cause error) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	job, err := u.jobRepository.GetByID(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	event := entity.JobEvent{Status: status, Variant: variant, Time: time.Now()}
+	if cause != nil {
+		event.Error = cause.Error()
+		job.Error = cause.Error()
+	}
+
+	job.Status = status
+	job.Variant = variant
+	job.Events = append(job.Events, event)
+	job.UpdatedAt = event.Time
+
+	if err := u.jobRepository.Save(ctx, job); err != nil {
+		return err
+	}
+
+	u.publishStatus(job)
+	return nil
+}
+
+// FailUnfinished marks every job that is neither done nor failed as failed
+// with cause and returns them. Workers only hold jobs in memory, so after a
+// restart nothing would ever finish them.
+func (u *jobUseCase) FailUnfinished(ctx context.Context, cause error) ([]*entity.Job, error) {
+	jobs, err := u.jobRepository.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []*entity.Job
+	for _, job := range jobs {
+		if job.Status == entity.JobStatusDone || job.Status == entity.JobStatusFailed {
+			continue
+		}
+		if err := u.UpdateStatus(ctx, job.ID, entity.JobStatusFailed, "", cause); err != nil {
+			return failed, err
+		}
+		failed = append(failed, job)
+	}
+
+	return failed, nil
+}
+
+func (u *jobUseCase) RecordProbe(ctx context.Context, jobID string, info *util.MediaInfo) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	job, err := u.jobRepository.GetByID(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	displayWidth, displayHeight := info.DisplaySize()
+	job.Probe = &entity.JobProbe{
+		Width:         info.Width,
+		Height:        info.Height,
+		DisplayWidth:  displayWidth,
+		DisplayHeight: displayHeight,
+		Rotation:      info.Rotation,
+		FPS:           info.FPS,
+		DurationMs:    info.Duration.Milliseconds(),
+	}
+	job.UpdatedAt = time.Now()
+
+	return u.jobRepository.Save(ctx, job)
+}
+
+// ReportProgress stores the latest progress of a running job and fans it out
+// to subscribers.
+func (u *jobUseCase) ReportProgress(event *model.JobProgressEvent) {
+	u.subMu.Lock()
+	defer u.subMu.Unlock()
+
+	u.latest[event.JobID] = event
+	u.broadcast(event)
+}
+
+// Subscribe returns a channel of progress events for the job, starting with
+// the most recent one if the job is running. The returned func must be called
+// to release the subscription.
+func (u *jobUseCase) Subscribe(jobID string) (<-chan *model.JobProgressEvent, func()) {
+	ch := make(chan *model.JobProgressEvent, 16)
+
+	u.subMu.Lock()
+	if u.subscribers[jobID] == nil {
+		u.subscribers[jobID] = make(map[chan *model.JobProgressEvent]struct{})
+	}
+	u.subscribers[jobID][ch] = struct{}{}
+	if latest, ok := u.latest[jobID]; ok {
+		ch <- latest
+	}
+	u.subMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			u.subMu.Lock()
+			defer u.subMu.Unlock()
+
+			delete(u.subscribers[jobID], ch)
+			if len(u.subscribers[jobID]) == 0 {
+				delete(u.subscribers, jobID)
+			}
+		})
+	}
+}
+
+func (u *jobUseCase) publishStatus(job *entity.Job) {
+	u.subMu.Lock()
+	defer u.subMu.Unlock()
+
+	event := &model.JobProgressEvent{
+		JobID:   job.ID,
+		Status:  job.Status,
+		Variant: job.Variant,
+		Error:   job.Error,
+	}
+	if previous, ok := u.latest[job.ID]; ok {
+		event.Percent = previous.Percent
+	}
+
+	switch job.Status {
+	case entity.JobStatusDone:
+		event.Percent = 100
+		delete(u.latest, job.ID)
+	case entity.JobStatusFailed:
+		delete(u.latest, job.ID)
+	default:
+		u.latest[job.ID] = event
+	}
+
+	u.broadcast(event)
+}
+
+// broadcast must be called with subMu held. Slow subscribers miss events
+// rather than stalling the encoder.
+func (u *jobUseCase) broadcast(event *model.JobProgressEvent) {
+	for ch := range u.subscribers[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (u *jobUseCase) GetJob(ctx context.Context, req *model.GetJobRequest) (*model.JobResponse, error) {
+	job, err := u.jobRepository.GetByID(ctx, req.JobID)
+	if err != nil {
+		if errors.Is(err, repository.ErrJobNotFound) {
+			return nil, fiber.NewError(http.StatusNotFound, "Requested job not found")
+		}
+		log.Printf("[USECASE][GetJob] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+	}
+
+	return toJobResponse(job), nil
+}
+
+func (u *jobUseCase) ListJobs(ctx context.Context, req *model.ListJobsRequest) ([]*model.JobResponse, error) {
+	if req.Status != "" && !entity.IsValidJobStatus(req.Status) {
+		return nil, fiber.NewError(http.StatusBadRequest, "Invalid job status filter")
+	}
+
+	jobs, err := u.jobRepository.List(ctx, req.Status)
+	if err != nil {
+		log.Printf("[USECASE][ListJobs] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, errorcode.INTERNAL_SERVER_ERROR)
+	}
+
+	responses := make([]*model.JobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		responses = append(responses, toJobResponse(job))
+	}
+
+	return responses, nil
+}
+
+func toJobResponse(job *entity.Job) *model.JobResponse {
+	events := make([]model.JobEventResponse, 0, len(job.Events))
+	for _, event := range job.Events {
+		events = append(events, model.JobEventResponse{
+			Status:  event.Status,
+			Variant: event.Variant,
+			Error:   event.Error,
+			Time:    event.Time,
+		})
+	}
+
+	var probe *model.JobProbeResponse
+	if job.Probe != nil {
+		probe = &model.JobProbeResponse{
+			Width:         job.Probe.Width,
+			Height:        job.Probe.Height,
+			DisplayWidth:  job.Probe.DisplayWidth,
+			DisplayHeight: job.Probe.DisplayHeight,
+			Rotation:      job.Probe.Rotation,
+			FPS:           job.Probe.FPS,
+			DurationMs:    job.Probe.DurationMs,
+		}
+	}
+
+	return &model.JobResponse{
+		ID:        job.ID,
+		VideoID:   job.VideoID,
+		Profile:   job.Profile,
+		Status:    job.Status,
+		Variant:   job.Variant,
+		Error:     job.Error,
+		Probe:     probe,
+		Events:    events,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
@@ -0,0 +1,160 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"ffmpeg-hls/util"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestJobUseCase(t *testing.T) JobUseCase {
+	t.Helper()
+
+	return NewJobUseCase(repository.NewJobRepository(openTestDB(t, newTestStore(t))))
+}
+
+func TestJobLifecycle(t *testing.T) {
+	ctx := context.Background()
+	jobUC := newTestJobUseCase(t)
+
+	req := &model.EncodeRequest{VideoID: "lecture.mp4"}
+	job, err := jobUC.CreateJob(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.JobID != job.ID || job.Status != entity.JobStatusQueued {
+		t.Fatalf("unexpected job after create: %+v", job)
+	}
+
+	if err := jobUC.UpdateStatus(ctx, job.ID, entity.JobStatusEncoding, "720p", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobUC.UpdateStatus(ctx, job.ID, entity.JobStatusFailed, "", errors.New("ffmpeg exited")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := jobUC.GetJob(ctx, &model.GetJobRequest{JobID: job.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != entity.JobStatusFailed || got.Error != "ffmpeg exited" {
+		t.Fatalf("unexpected job state: %+v", got)
+	}
+	if len(got.Events) != 3 || got.Events[1].Variant != "720p" {
+		t.Fatalf("unexpected events: %+v", got.Events)
+	}
+
+	failed, err := jobUC.ListJobs(ctx, &model.ListJobsRequest{Status: entity.JobStatusFailed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("expected 1 failed job, got %d", len(failed))
+	}
+
+	if _, err := jobUC.ListJobs(ctx, &model.ListJobsRequest{Status: "bogus"}); err == nil {
+		t.Fatal("expected error for invalid status filter")
+	}
+	if _, err := jobUC.GetJob(ctx, &model.GetJobRequest{JobID: "missing"}); err == nil {
+		t.Fatal("expected error for missing job")
+	}
+}
+
+func TestJobProgressSubscribe(t *testing.T) {
+	ctx := context.Background()
+	jobUC := newTestJobUseCase(t)
+
+	job, err := jobUC.CreateJob(ctx, &model.EncodeRequest{VideoID: "lecture.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobUC.ReportProgress(&model.JobProgressEvent{JobID: job.ID, Status: entity.JobStatusEncoding, Percent: 40})
+
+	events, unsubscribe := jobUC.Subscribe(job.ID)
+	defer unsubscribe()
+
+	if latest := <-events; latest.Percent != 40 {
+		t.Fatalf("expected latest progress on subscribe, got %+v", latest)
+	}
+
+	if err := jobUC.UpdateStatus(ctx, job.ID, entity.JobStatusDone, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if done := <-events; done.Status != entity.JobStatusDone || done.Percent != 100 {
+		t.Fatalf("unexpected terminal event: %+v", done)
+	}
+}
+
+func TestRecoverInterrupted(t *testing.T) {
+	ctx := context.Background()
+
+	store := newTestStore(t)
+	db := openTestDB(t, store)
+	jobUC := NewJobUseCase(repository.NewJobRepository(db))
+	videoRepo := repository.NewVideoRepository(db)
+	u := &encodeUseCase{objectStore: store, jobUseCase: jobUC, videoRepository: videoRepo, sourceDir: t.TempDir()}
+
+	statuses := []string{entity.JobStatusQueued, entity.JobStatusEncoding, entity.JobStatusDone}
+	jobIDs := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		job, err := jobUC.CreateJob(ctx, &model.EncodeRequest{VideoID: status})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != entity.JobStatusQueued {
+			if err := jobUC.UpdateStatus(ctx, job.ID, status, "", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		jobIDs = append(jobIDs, job.ID)
+	}
+	// A tus upload handed to the encoder and a direct upload still in the
+	// bucket, both left behind by the interrupted jobs.
+	if err := os.WriteFile(u.sourcePath("queued"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutObject(ctx, IncomingPrefix+"encoding", strings.NewReader("source"), 6, util.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for id, status := range map[string]string{"encoding": entity.VideoStatusProcessing, "done": entity.VideoStatusReady} {
+		if err := videoRepo.Save(ctx, &entity.Video{ID: id, Dir: "videos/" + id, Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := u.RecoverInterrupted(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{entity.JobStatusFailed, entity.JobStatusFailed, entity.JobStatusDone} {
+		job, err := jobUC.GetJob(ctx, &model.GetJobRequest{JobID: jobIDs[i]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != want {
+			t.Errorf("job %s: got status %s, want %s", statuses[i], job.Status, want)
+		}
+	}
+	for id, want := range map[string]string{"encoding": entity.VideoStatusFailed, "done": entity.VideoStatusReady} {
+		video, err := videoRepo.GetByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if video.Status != want {
+			t.Errorf("video %s: got status %s, want %s", id, video.Status, want)
+		}
+	}
+	if _, err := os.Stat(u.sourcePath("queued")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want the uploaded source removed", err)
+	}
+	if _, err := store.StatObject(ctx, IncomingPrefix+"encoding"); !errors.Is(err, util.ErrObjectNotFound) {
+		t.Errorf("got %v, want the direct upload source removed", err)
+	}
+}
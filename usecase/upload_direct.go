@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// IncomingPrefix is where clients upload sources directly to the bucket.
+	IncomingPrefix = "incoming/"
+
+	// maxSinglePutSize is the largest object S3 accepts in a single PUT.
+	maxSinglePutSize = 5 << 30
+
+	// directUploadGrace is how long after the presigned URL expires the
+	// upload can still be completed, so a PUT that finished just in time is
+	// not lost.
+	directUploadGrace = time.Hour
+)
+
+// CreateDirectUpload reserves a video ID and returns a presigned URL the
+// client PUTs the source to, bypassing this API.
+func (u *uploadUseCase) CreateDirectUpload(ctx context.Context, req *model.CreateDirectUploadRequest) (*model.DirectUploadResponse, error) {
+	if req.Size <= 0 {
+		return nil, fiber.NewError(http.StatusBadRequest, "size must be a positive number of bytes")
+	}
+	maxSize := min(u.config.MaxSize, maxSinglePutSize)
+	if req.Size > maxSize {
+		return nil, fiber.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d bytes", maxSize))
+	}
+
+	encodeRequest := &model.EncodeRequest{
+		OriginalFilename: req.Filename,
+		Profile:          req.Profile,
+		Title:            req.Title,
+		Owner:            req.Owner,
+	}
+	if err := u.encodeUseCase.ValidateRequest(ctx, encodeRequest); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := &entity.Upload{
+		ID:               uuid.NewString(),
+		Length:           req.Size,
+		VideoID:          encodeRequest.VideoID,
+		OriginalFilename: encodeRequest.OriginalFilename,
+		Profile:          encodeRequest.Profile,
+		Title:            encodeRequest.Title,
+		Owner:            encodeRequest.Owner,
+		ObjectKey:        IncomingPrefix + encodeRequest.VideoID,
+		ExpiresAt:        now.Add(u.config.Expiry + directUploadGrace),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	uploadURL, err := u.objectStore.PresignedPutObject(ctx, upload.ObjectKey, upload.Length, u.config.Expiry)
+	if err != nil {
+		log.Printf("[USECASE][CreateDirectUpload] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	if err := u.uploadRepository.Save(ctx, upload); err != nil {
+		log.Printf("[USECASE][CreateDirectUpload] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	response := toDirectUploadResponse(upload)
+	response.UploadURL = uploadURL
+	return response, nil
+}
+
+// CompleteDirectUpload checks that the client uploaded the whole source and
+// creates the job that downloads it from storage, returning its encode
+// request.
+func (u *uploadUseCase) CompleteDirectUpload(ctx context.Context, req *model.UploadRequest) (*model.DirectUploadResponse, error) {
+	if !u.lock(req.UploadID) {
+		return nil, fiber.NewError(http.StatusLocked, "Upload is being completed by another request")
+	}
+	defer u.unlock(req.UploadID)
+
+	upload, err := u.activeUpload(ctx, req.UploadID, true)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Completed {
+		return nil, fiber.NewError(http.StatusConflict, "Upload is already complete")
+	}
+
+	info, err := u.objectStore.StatObject(ctx, upload.ObjectKey)
+	if errors.Is(err, util.ErrObjectNotFound) {
+		return nil, fiber.NewError(http.StatusConflict, "Source has not been uploaded yet")
+	}
+	if err != nil {
+		log.Printf("[USECASE][CompleteDirectUpload %s] %v", upload.ID, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+	if info.Size != upload.Length {
+		// The upload URL only accepts the expected size, so this object was
+		// put some other way. It is removed, the client can PUT again.
+		if err := u.objectStore.RemoveObject(ctx, upload.ObjectKey); err != nil {
+			log.Printf("[USECASE][CompleteDirectUpload %s] %v", upload.ID, err)
+		}
+		return nil, fiber.NewError(http.StatusConflict, fmt.Sprintf("Uploaded source has %d bytes, expected %d", info.Size, upload.Length))
+	}
+
+	encodeRequest := &model.EncodeRequest{
+		VideoID:          upload.VideoID,
+		OriginalFilename: upload.OriginalFilename,
+		SourceKey:        upload.ObjectKey,
+		Profile:          upload.Profile,
+		Title:            upload.Title,
+		Owner:            upload.Owner,
+	}
+	upload.Offset = info.Size
+	job, err := u.startJob(ctx, upload, encodeRequest)
+	if err != nil {
+		log.Printf("[USECASE][CompleteDirectUpload %s] %v", upload.ID, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	response := toDirectUploadResponse(upload)
+	response.Encode = encodeRequest
+	response.Job = job
+	return response, nil
+}
+
+func toDirectUploadResponse(upload *entity.Upload) *model.DirectUploadResponse {
+	return &model.DirectUploadResponse{
+		ID:        upload.ID,
+		VideoID:   upload.VideoID,
+		Size:      upload.Length,
+		Method:    http.MethodPut,
+		Completed: upload.Completed,
+		ExpiresAt: upload.ExpiresAt,
+	}
+}
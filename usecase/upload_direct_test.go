@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirectUpload(t *testing.T) {
+	u := newUploadTestUseCase(t)
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("video"), 100)
+
+	_, err := u.CreateDirectUpload(ctx, &model.CreateDirectUploadRequest{Filename: "a.mp4"})
+	expectStatus(t, err, http.StatusBadRequest)
+	_, err = u.CreateDirectUpload(ctx, &model.CreateDirectUploadRequest{Size: 2 << 20, Filename: "a.mp4"})
+	expectStatus(t, err, http.StatusRequestEntityTooLarge)
+
+	upload, err := u.CreateDirectUpload(ctx, &model.CreateDirectUploadRequest{Size: int64(len(data)), Filename: "lecture.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(upload.UploadURL, "size=500") || upload.Method != http.MethodPut {
+		t.Fatalf("got %+v, want a presigned PUT url for 500 bytes", upload)
+	}
+
+	// Direct and tus uploads are not interchangeable.
+	_, err = u.GetUpload(ctx, &model.UploadRequest{UploadID: upload.ID})
+	expectStatus(t, err, http.StatusNotFound)
+
+	request := &model.UploadRequest{UploadID: upload.ID}
+	_, err = u.CompleteDirectUpload(ctx, request)
+	expectStatus(t, err, http.StatusConflict)
+
+	key := IncomingPrefix + upload.VideoID
+	if err := u.objectStore.PutObject(ctx, key, bytes.NewReader(data[1:]), -1, util.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.CompleteDirectUpload(ctx, request)
+	expectStatus(t, err, http.StatusConflict)
+	if _, err := u.objectStore.StatObject(ctx, key); !errors.Is(err, util.ErrObjectNotFound) {
+		t.Fatalf("got %v, want the source of the wrong size removed", err)
+	}
+
+	if err := u.objectStore.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)), util.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// A job that cannot be created leaves the upload to be completed again.
+	jobs := &failingJobRepository{JobRepository: u.jobUseCase.(*jobUseCase).jobRepository, fail: true}
+	u.jobUseCase = NewJobUseCase(jobs)
+	_, err = u.CompleteDirectUpload(ctx, request)
+	expectStatus(t, err, http.StatusInternalServerError)
+	jobs.fail = false
+
+	completed, err := u.CompleteDirectUpload(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.Job == nil || completed.Encode.JobID != completed.Job.ID {
+		t.Fatalf("got %+v, want the job created with the completion", completed)
+	}
+	if !completed.Completed || completed.Encode == nil || completed.Encode.SourceKey != key || completed.Encode.VideoID != upload.VideoID {
+		t.Fatalf("got %+v, want a completed upload encoding from %s", completed, key)
+	}
+
+	_, err = u.CompleteDirectUpload(ctx, request)
+	expectStatus(t, err, http.StatusConflict)
+
+	// Purging a completed upload leaves the source for the encoder.
+	if err := u.remove(ctx, mustGetUpload(t, u, upload.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.objectStore.StatObject(ctx, key); err != nil {
+		t.Fatalf("source removed with the upload: %v", err)
+	}
+}
+
+func TestDirectUploadExpire(t *testing.T) {
+	u := newUploadTestUseCase(t)
+	ctx := context.Background()
+
+	upload, err := u.CreateDirectUpload(ctx, &model.CreateDirectUploadRequest{Size: 3, Filename: "a.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := IncomingPrefix + upload.VideoID
+	if err := u.objectStore.PutObject(ctx, key, bytes.NewReader([]byte("abc")), 3, util.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	record := mustGetUpload(t, u, upload.ID)
+	record.ExpiresAt = time.Now().Add(-time.Second)
+	if err := u.uploadRepository.Save(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = u.CompleteDirectUpload(ctx, &model.UploadRequest{UploadID: upload.ID})
+	expectStatus(t, err, http.StatusGone)
+
+	purged, err := u.PurgeExpired(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("purged %d, %v; want 1", purged, err)
+	}
+	if _, err := u.objectStore.StatObject(ctx, key); err != util.ErrObjectNotFound {
+		t.Fatalf("got %v, want the abandoned source removed", err)
+	}
+}
+
+func TestDownloadSource(t *testing.T) {
+	ctx := context.Background()
+	store := util.NewLocalStore(t.TempDir(), "http://localhost", []byte("secret"))
+	data := bytes.Repeat([]byte("source"), 1000)
+	if err := store.PutObject(ctx, "incoming/v1", bytes.NewReader(data), int64(len(data)), util.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tmp", "v1")
+	if err := downloadSource(ctx, store, "incoming/v1", path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, %v; want %d", len(got), err, len(data))
+	}
+
+	if err := downloadSource(ctx, store, "incoming/missing", filepath.Join(t.TempDir(), "v2")); err == nil {
+		t.Fatal("downloaded a missing object")
+	}
+
+	removeSource(ctx, store, "incoming/v1")
+	if _, err := store.StatObject(ctx, "incoming/v1"); !errors.Is(err, util.ErrObjectNotFound) {
+		t.Fatalf("expected the source removed, got %v", err)
+	}
+}
+
+func mustGetUpload(t *testing.T, u *uploadUseCase, id string) *entity.Upload {
+	t.Helper()
+
+	upload, err := u.uploadRepository.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return upload
+}
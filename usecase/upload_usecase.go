@@ -0,0 +1,430 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"ffmpeg-hls/util"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// UploadUseCase implements the tus 1.0 core protocol with the creation,
+// expiration and termination extensions on top of files on local disk, and
+// direct uploads of the source to the object store through presigned URLs.
+type UploadUseCase interface {
+	CreateUpload(ctx context.Context, req *model.CreateUploadRequest) (*model.UploadResponse, error)
+	GetUpload(ctx context.Context, req *model.UploadRequest) (*model.UploadResponse, error)
+	AppendUpload(ctx context.Context, req *model.AppendUploadRequest) (*model.UploadResponse, error)
+	TerminateUpload(ctx context.Context, req *model.UploadRequest) error
+	PurgeExpired(ctx context.Context) (int, error)
+	CreateDirectUpload(ctx context.Context, req *model.CreateDirectUploadRequest) (*model.DirectUploadResponse, error)
+	CompleteDirectUpload(ctx context.Context, req *model.UploadRequest) (*model.DirectUploadResponse, error)
+}
+
+type uploadUseCase struct {
+	// mu guards busy, the uploads a request is currently writing to.
+	mu               sync.Mutex
+	busy             map[string]bool
+	uploadRepository repository.UploadRepository
+	encodeUseCase    EncodeUseCase
+	jobUseCase       JobUseCase
+	objectStore      util.ObjectStore
+	config           util.TusConfig
+	sourceDir        string // where completed uploads are handed to the encoder
+}
+
+func NewUploadUseCase(uploadRepository repository.UploadRepository, encodeUseCase EncodeUseCase, jobUseCase JobUseCase, objectStore util.ObjectStore, config util.TusConfig) UploadUseCase {
+	return &uploadUseCase{
+		busy:             make(map[string]bool),
+		uploadRepository: uploadRepository,
+		encodeUseCase:    encodeUseCase,
+		jobUseCase:       jobUseCase,
+		objectStore:      objectStore,
+		config:           config,
+		sourceDir:        ResolvePath("usecase", "tmp"),
+	}
+}
+
+// CreateUpload validates the encode settings up front, so a client learns
+// about a bad profile or file name before sending gigabytes.
+func (u *uploadUseCase) CreateUpload(ctx context.Context, req *model.CreateUploadRequest) (*model.UploadResponse, error) {
+	if req.Length <= 0 {
+		return nil, fiber.NewError(http.StatusBadRequest, "Upload-Length must be a positive number of bytes")
+	}
+	if req.Length > u.config.MaxSize {
+		return nil, fiber.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d bytes", u.config.MaxSize))
+	}
+
+	encodeRequest := &model.EncodeRequest{
+		OriginalFilename: req.Filename,
+		Profile:          req.Profile,
+		Title:            req.Title,
+		Owner:            req.Owner,
+	}
+	if err := u.encodeUseCase.ValidateRequest(ctx, encodeRequest); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := &entity.Upload{
+		ID:               uuid.NewString(),
+		Length:           req.Length,
+		VideoID:          encodeRequest.VideoID,
+		OriginalFilename: encodeRequest.OriginalFilename,
+		Profile:          encodeRequest.Profile,
+		Title:            encodeRequest.Title,
+		Owner:            encodeRequest.Owner,
+		ExpiresAt:        now.Add(u.config.Expiry),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	file, err := os.OpenFile(u.partPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("[USECASE][CreateUpload] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+	file.Close()
+
+	if err := u.uploadRepository.Save(ctx, upload); err != nil {
+		log.Printf("[USECASE][CreateUpload] %v", err)
+		os.Remove(u.partPath(upload.ID))
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	return toUploadResponse(upload), nil
+}
+
+// GetUpload also finishes an upload whose data all arrived but that a
+// failure or a restart left incomplete, since tus clients check the offset
+// with a HEAD before resuming and would not send another PATCH.
+func (u *uploadUseCase) GetUpload(ctx context.Context, req *model.UploadRequest) (*model.UploadResponse, error) {
+	upload, err := u.activeUpload(ctx, req.UploadID, false)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Completed || upload.Offset < upload.Length || !u.lock(upload.ID) {
+		return toUploadResponse(upload), nil
+	}
+	defer u.unlock(upload.ID)
+
+	// Reload under the lock, a PATCH may have completed it meanwhile.
+	upload, err = u.activeUpload(ctx, req.UploadID, false)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Completed {
+		return toUploadResponse(upload), nil
+	}
+	return u.finish(ctx, upload)
+}
+
+// AppendUpload writes the request body at the upload offset. Whatever arrives
+// before the connection drops is kept, so the client resumes from there.
+func (u *uploadUseCase) AppendUpload(ctx context.Context, req *model.AppendUploadRequest) (*model.UploadResponse, error) {
+	if !u.lock(req.UploadID) {
+		return nil, fiber.NewError(http.StatusLocked, "Upload is being written by another request")
+	}
+	defer u.unlock(req.UploadID)
+
+	upload, err := u.activeUpload(ctx, req.UploadID, false)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Completed {
+		return nil, fiber.NewError(http.StatusConflict, "Upload is already complete")
+	}
+	if req.Offset != upload.Offset {
+		return nil, fiber.NewError(http.StatusConflict, fmt.Sprintf("Upload-Offset %d does not match the current offset %d", req.Offset, upload.Offset))
+	}
+
+	remaining := upload.Length - upload.Offset
+	if req.ContentLength > remaining {
+		return nil, fiber.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the remaining %d bytes", remaining))
+	}
+
+	if remaining == 0 {
+		// Retrying the completion of an upload that has all its data.
+		return u.finish(ctx, upload)
+	}
+
+	written, copyErr := u.writeAt(upload, io.LimitReader(req.Body, remaining))
+	if written > 0 {
+		upload.Offset += written
+		upload.UpdatedAt = time.Now()
+		upload.ExpiresAt = upload.UpdatedAt.Add(u.config.Expiry)
+		if err := u.uploadRepository.Save(ctx, upload); err != nil {
+			log.Printf("[USECASE][AppendUpload %s] %v", upload.ID, err)
+			return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+		}
+	}
+	if copyErr != nil {
+		log.Printf("[USECASE][AppendUpload %s] stopped at offset %d: %v", upload.ID, upload.Offset, copyErr)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Upload interrupted, resume from the current offset")
+	}
+
+	if upload.Offset < upload.Length {
+		return toUploadResponse(upload), nil
+	}
+	return u.finish(ctx, upload)
+}
+
+// finish completes an upload that received all its data. On failure the
+// upload stays incomplete at its full offset, the next HEAD or PATCH retries.
+func (u *uploadUseCase) finish(ctx context.Context, upload *entity.Upload) (*model.UploadResponse, error) {
+	encodeRequest, err := u.complete(ctx, upload)
+	if err != nil {
+		log.Printf("[USECASE][CompleteUpload %s] %v", upload.ID, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	response := toUploadResponse(upload)
+	response.Encode = encodeRequest
+	return response, nil
+}
+
+// writeAt appends to the partial file at the committed offset. Bytes past
+// the offset, left by a write the server did not get to record, are
+// discarded first.
+func (u *uploadUseCase) writeAt(upload *entity.Upload, body io.Reader) (int64, error) {
+	file, err := os.OpenFile(u.partPath(upload.ID), os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := file.Truncate(upload.Offset); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return 0, err
+	}
+
+	written, err := io.Copy(file, body)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// complete moves the finished upload to the encoder input and creates its
+// encode job, and only then marks the upload complete. A file already moved
+// by an earlier attempt that failed or was cut short by a restart is used as
+// is. The record is kept until it expires so that clients checking the
+// offset see it done.
+func (u *uploadUseCase) complete(ctx context.Context, upload *entity.Upload) (*model.EncodeRequest, error) {
+	source := u.sourcePath(upload)
+	if _, err := os.Stat(u.partPath(upload.ID)); err == nil {
+		if err := os.MkdirAll(u.sourceDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := moveFile(u.partPath(upload.ID), source); err != nil {
+			return nil, fmt.Errorf("move upload: %w", err)
+		}
+	} else if _, err := os.Stat(source); err != nil {
+		return nil, fmt.Errorf("find upload: %w", err)
+	}
+
+	encodeRequest := &model.EncodeRequest{
+		VideoID:          upload.VideoID,
+		OriginalFilename: upload.OriginalFilename,
+		Profile:          upload.Profile,
+		Title:            upload.Title,
+		Owner:            upload.Owner,
+	}
+	if _, err := u.startJob(ctx, upload, encodeRequest); err != nil {
+		return nil, err
+	}
+
+	return encodeRequest, nil
+}
+
+// startJob creates the encode job of a finished upload and then saves the
+// upload as complete. When the save fails the job is failed, since nothing
+// will run it, and the upload can be completed again.
+func (u *uploadUseCase) startJob(ctx context.Context, upload *entity.Upload, encodeRequest *model.EncodeRequest) (*model.JobResponse, error) {
+	job, err := u.jobUseCase.CreateJob(ctx, encodeRequest)
+	if err != nil {
+		return nil, fmt.Errorf("create job: %w", err)
+	}
+
+	upload.Completed = true
+	upload.UpdatedAt = time.Now()
+	if err := u.uploadRepository.Save(ctx, upload); err != nil {
+		upload.Completed = false
+		if updateErr := u.jobUseCase.UpdateStatus(ctx, job.ID, entity.JobStatusFailed, "", err); updateErr != nil {
+			log.Printf("[USECASE][StartJob %s] %v", upload.ID, updateErr)
+		}
+		return nil, fmt.Errorf("save upload: %w", err)
+	}
+
+	return job, nil
+}
+
+func (u *uploadUseCase) TerminateUpload(ctx context.Context, req *model.UploadRequest) error {
+	if !u.lock(req.UploadID) {
+		return fiber.NewError(http.StatusLocked, "Upload is being written by another request")
+	}
+	defer u.unlock(req.UploadID)
+
+	upload, err := u.activeUpload(ctx, req.UploadID, false)
+	if err != nil {
+		return err
+	}
+
+	if err := u.remove(ctx, upload); err != nil {
+		log.Printf("[USECASE][TerminateUpload %s] %v", upload.ID, err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+	return nil
+}
+
+// PurgeExpired removes uploads past their expiry together with their
+// partial data and returns how many were removed.
+func (u *uploadUseCase) PurgeExpired(ctx context.Context) (int, error) {
+	uploads, err := u.uploadRepository.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, upload := range uploads {
+		if now.Before(upload.ExpiresAt) || !u.lock(upload.ID) {
+			continue
+		}
+		err := u.remove(ctx, upload)
+		u.unlock(upload.ID)
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// activeUpload loads an upload, treating expired ones as gone. A tus upload
+// looked up as a direct one, or the reverse, is not found.
+func (u *uploadUseCase) activeUpload(ctx context.Context, id string, direct bool) (*entity.Upload, error) {
+	upload, err := u.uploadRepository.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrUploadNotFound) {
+		return nil, fiber.NewError(http.StatusNotFound, "Upload not found")
+	}
+	if err != nil {
+		log.Printf("[USECASE][GetUpload %s] %v", id, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	if (upload.ObjectKey != "") != direct {
+		return nil, fiber.NewError(http.StatusNotFound, "Upload not found")
+	}
+	if !time.Now().Before(upload.ExpiresAt) {
+		return nil, fiber.NewError(http.StatusGone, "Upload expired")
+	}
+	return upload, nil
+}
+
+// remove deletes the upload with any data it left behind. A completed direct
+// upload keeps its object, the encoder reads the source from it and removes
+// it when the job finishes.
+func (u *uploadUseCase) remove(ctx context.Context, upload *entity.Upload) error {
+	if upload.ObjectKey != "" {
+		if !upload.Completed {
+			if err := u.objectStore.RemoveObject(ctx, upload.ObjectKey); err != nil {
+				return err
+			}
+		}
+	} else if !upload.Completed {
+		// An upload whose completion failed may already have been moved.
+		for _, path := range []string{u.partPath(upload.ID), u.sourcePath(upload)} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return u.uploadRepository.Delete(ctx, upload.ID)
+}
+
+func (u *uploadUseCase) partPath(id string) string {
+	return filepath.Join(u.config.Dir, id+".part")
+}
+
+func (u *uploadUseCase) sourcePath(upload *entity.Upload) string {
+	return filepath.Join(u.sourceDir, upload.VideoID)
+}
+
+func (u *uploadUseCase) lock(id string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.busy[id] {
+		return false
+	}
+	u.busy[id] = true
+	return true
+}
+
+func (u *uploadUseCase) unlock(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.busy, id)
+}
+
+// moveFile renames src to dst, copying when they are on different file
+// systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+func toUploadResponse(upload *entity.Upload) *model.UploadResponse {
+	return &model.UploadResponse{
+		ID:        upload.ID,
+		VideoID:   upload.VideoID,
+		Length:    upload.Length,
+		Offset:    upload.Offset,
+		Completed: upload.Completed,
+		ExpiresAt: upload.ExpiresAt,
+	}
+}
@@ -0,0 +1,212 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"ffmpeg-hls/util"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newUploadTestUseCase(t *testing.T) *uploadUseCase {
+	t.Helper()
+
+	store := newTestStore(t)
+	db := openTestDB(t, store)
+	u := NewUploadUseCase(
+		repository.NewUploadRepository(db),
+		newTestEncodeUseCase(t),
+		NewJobUseCase(repository.NewJobRepository(db)),
+		store,
+		util.TusConfig{Dir: t.TempDir(), MaxSize: 1 << 20, Expiry: time.Hour},
+	).(*uploadUseCase)
+	u.sourceDir = t.TempDir()
+	return u
+}
+
+// brokenReader delivers data and then fails like a dropped connection.
+type brokenReader struct {
+	data []byte
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadResume(t *testing.T) {
+	u := newUploadTestUseCase(t)
+	ctx := context.Background()
+	source := bytes.Repeat([]byte("0123456789"), 100)
+
+	_, err := u.CreateUpload(ctx, &model.CreateUploadRequest{Length: 2 << 20, Filename: "lecture.mp4"})
+	expectStatus(t, err, http.StatusRequestEntityTooLarge)
+	_, err = u.CreateUpload(ctx, &model.CreateUploadRequest{Length: 10, Filename: "../lecture.mp4"})
+	expectStatus(t, err, http.StatusBadRequest)
+
+	created, err := u.CreateUpload(ctx, &model.CreateUploadRequest{Length: int64(len(source)), Filename: "lecture.mp4", Title: "Lecture"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection drops after 300 bytes; they are kept.
+	_, err = u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: created.ID, Offset: 0, ContentLength: -1, Body: &brokenReader{data: source[:300]}})
+	expectStatus(t, err, http.StatusInternalServerError)
+
+	head, err := u.GetUpload(ctx, &model.UploadRequest{UploadID: created.ID})
+	if err != nil || head.Offset != 300 {
+		t.Fatalf("expected offset 300 after the interruption, got %+v (%v)", head, err)
+	}
+
+	_, err = u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: created.ID, Offset: 0, Body: bytes.NewReader(source)})
+	expectStatus(t, err, http.StatusConflict)
+	_, err = u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: created.ID, Offset: 300, ContentLength: int64(len(source)), Body: bytes.NewReader(source)})
+	expectStatus(t, err, http.StatusRequestEntityTooLarge)
+
+	partial, err := u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: created.ID, Offset: 300, ContentLength: 200, Body: bytes.NewReader(source[300:500])})
+	if err != nil || partial.Offset != 500 || partial.Encode != nil {
+		t.Fatalf("unexpected partial append %+v (%v)", partial, err)
+	}
+
+	done, err := u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: created.ID, Offset: 500, ContentLength: -1, Body: bytes.NewReader(source[500:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done.Completed || done.Encode == nil || done.Encode.VideoID != created.VideoID || done.Encode.JobID == "" || done.Encode.Title != "Lecture" || done.Encode.OriginalFilename != "lecture.mp4" {
+		t.Fatalf("unexpected completion %+v", done)
+	}
+
+	data, err := os.ReadFile(filepath.Join(u.sourceDir, created.VideoID))
+	if err != nil || !bytes.Equal(data, source) {
+		t.Fatalf("source not handed to the encoder intact (%v)", err)
+	}
+
+	head, err = u.GetUpload(ctx, &model.UploadRequest{UploadID: created.ID})
+	if err != nil || !head.Completed || head.Offset != head.Length {
+		t.Fatalf("completed upload should still report its offset, got %+v (%v)", head, err)
+	}
+	_, err = u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: created.ID, Offset: head.Offset, Body: bytes.NewReader(nil)})
+	expectStatus(t, err, http.StatusConflict)
+}
+
+// failingJobRepository fails to save jobs while fail is set.
+type failingJobRepository struct {
+	repository.JobRepository
+	fail bool
+}
+
+func (r *failingJobRepository) Save(ctx context.Context, job *entity.Job) error {
+	if r.fail {
+		return errors.New("disk full")
+	}
+	return r.JobRepository.Save(ctx, job)
+}
+
+func TestUploadCompletionRetry(t *testing.T) {
+	u := newUploadTestUseCase(t)
+	ctx := context.Background()
+	jobs := &failingJobRepository{JobRepository: u.jobUseCase.(*jobUseCase).jobRepository, fail: true}
+	u.jobUseCase = NewJobUseCase(jobs)
+
+	created, err := u.CreateUpload(ctx, &model.CreateUploadRequest{Length: 10, Filename: "lecture.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data is all there but the job cannot be created.
+	_, err = u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: created.ID, Offset: 0, ContentLength: 10, Body: bytes.NewReader([]byte("0123456789"))})
+	expectStatus(t, err, http.StatusInternalServerError)
+	_, err = u.GetUpload(ctx, &model.UploadRequest{UploadID: created.ID})
+	expectStatus(t, err, http.StatusInternalServerError)
+
+	// The HEAD a client sends before resuming completes the upload.
+	jobs.fail = false
+	head, err := u.GetUpload(ctx, &model.UploadRequest{UploadID: created.ID})
+	if err != nil || !head.Completed || head.Encode == nil || head.Encode.JobID == "" {
+		t.Fatalf("expected the HEAD to complete the upload, got %+v (%v)", head, err)
+	}
+	data, err := os.ReadFile(filepath.Join(u.sourceDir, created.VideoID))
+	if err != nil || string(data) != "0123456789" {
+		t.Fatalf("source not handed to the encoder intact (%v)", err)
+	}
+
+	head, err = u.GetUpload(ctx, &model.UploadRequest{UploadID: created.ID})
+	if err != nil || !head.Completed || head.Encode != nil {
+		t.Fatalf("expected the upload to be completed once, got %+v (%v)", head, err)
+	}
+
+	// An empty PATCH retries the completion as well.
+	retried, err := u.CreateUpload(ctx, &model.CreateUploadRequest{Length: 3, Filename: "lecture.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.fail = true
+	_, err = u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: retried.ID, Offset: 0, ContentLength: 3, Body: bytes.NewReader([]byte("abc"))})
+	expectStatus(t, err, http.StatusInternalServerError)
+	jobs.fail = false
+	done, err := u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: retried.ID, Offset: 3, Body: bytes.NewReader(nil)})
+	if err != nil || !done.Completed || done.Encode == nil {
+		t.Fatalf("expected the empty PATCH to complete the upload, got %+v (%v)", done, err)
+	}
+}
+
+func TestUploadTerminateAndExpire(t *testing.T) {
+	u := newUploadTestUseCase(t)
+	ctx := context.Background()
+
+	terminated, err := u.CreateUpload(ctx, &model.CreateUploadRequest{Length: 10, Filename: "a.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.TerminateUpload(ctx, &model.UploadRequest{UploadID: terminated.ID}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.GetUpload(ctx, &model.UploadRequest{UploadID: terminated.ID})
+	expectStatus(t, err, http.StatusNotFound)
+	if _, err := os.Stat(u.partPath(terminated.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial data not removed: %v", err)
+	}
+
+	expired, err := u.CreateUpload(ctx, &model.CreateUploadRequest{Length: 10, Filename: "b.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := u.CreateUpload(ctx, &model.CreateUploadRequest{Length: 10, Filename: "c.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := u.uploadRepository.GetByID(ctx, expired.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := u.uploadRepository.Save(ctx, upload); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = u.AppendUpload(ctx, &model.AppendUploadRequest{UploadID: expired.ID, Body: bytes.NewReader([]byte("x"))})
+	expectStatus(t, err, http.StatusGone)
+
+	purged, err := u.PurgeExpired(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("expected one purged upload, got %d (%v)", purged, err)
+	}
+	_, err = u.GetUpload(ctx, &model.UploadRequest{UploadID: expired.ID})
+	expectStatus(t, err, http.StatusNotFound)
+	if _, err := u.GetUpload(ctx, &model.UploadRequest{UploadID: active.ID}); err != nil {
+		t.Fatalf("active upload purged: %v", err)
+	}
+}
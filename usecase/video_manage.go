@@ -0,0 +1,201 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 5000
+	maxTags              = 20
+	maxTagLength         = 50
+)
+
+func (u *videoUseCase) ListVideos(ctx context.Context, req *model.ListVideosRequest) ([]*model.VideoResponse, error) {
+	videos, err := u.videoRepository.List(ctx)
+	if err != nil {
+		log.Printf("[USECASE][ListVideos] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	responses := make([]*model.VideoResponse, 0, len(videos))
+	for _, video := range videos {
+		if req.Owner != "" && video.Owner != req.Owner {
+			continue
+		}
+		if req.Status != "" && video.Status != req.Status {
+			continue
+		}
+		responses = append(responses, toVideoResponse(video))
+	}
+
+	return responses, nil
+}
+
+func (u *videoUseCase) GetVideo(ctx context.Context, req *model.GetVideoRequest) (*model.VideoResponse, error) {
+	video, err := u.getVideo(ctx, req.VideoID)
+	if err != nil {
+		return nil, err
+	}
+
+	return toVideoResponse(video), nil
+}
+
+func (u *videoUseCase) UpdateVideo(ctx context.Context, req *model.UpdateVideoRequest) (*model.VideoResponse, error) {
+	if err := normalizeVideoUpdate(req); err != nil {
+		return nil, fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	video, err := u.videoRepository.Update(ctx, req.VideoID, func(video *entity.Video) error {
+		if req.Title != nil {
+			video.Title = *req.Title
+		}
+		if req.Description != nil {
+			video.Description = *req.Description
+		}
+		if req.Tags != nil {
+			video.Tags = *req.Tags
+		}
+		video.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, repository.ErrVideoNotFound) {
+		return nil, fiber.NewError(http.StatusNotFound, "Requested video not found")
+	}
+	if err != nil {
+		log.Printf("[USECASE][UpdateVideo %s] %v", req.VideoID, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	return toVideoResponse(video), nil
+}
+
+// normalizeVideoUpdate trims the editable fields and checks their limits.
+// Tags are deduplicated, keeping their order.
+func normalizeVideoUpdate(req *model.UpdateVideoRequest) error {
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" || utf8.RuneCountInString(title) > maxTitleLength {
+			return fmt.Errorf("title must be between 1 and %d characters", maxTitleLength)
+		}
+		req.Title = &title
+	}
+
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		if utf8.RuneCountInString(description) > maxDescriptionLength {
+			return fmt.Errorf("description must be at most %d characters", maxDescriptionLength)
+		}
+		req.Description = &description
+	}
+
+	if req.Tags != nil {
+		seen := make(map[string]bool)
+		tags := make([]string, 0, len(*req.Tags))
+		for _, tag := range *req.Tags {
+			tag = strings.TrimSpace(tag)
+			if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+				return fmt.Errorf("tags must be between 1 and %d characters", maxTagLength)
+			}
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) > maxTags {
+			return fmt.Errorf("a video can have at most %d tags", maxTags)
+		}
+		req.Tags = &tags
+	}
+
+	return nil
+}
+
+// DeleteVideo removes every object under the video prefix, legacy keys in
+// secrets/ included, then its content keys and finally the record, so a
+// failed delete can simply be retried. Videos still being encoded are
+// refused since the encoder would upload them again.
+func (u *videoUseCase) DeleteVideo(ctx context.Context, req *model.DeleteVideoRequest) error {
+	video, err := u.getVideo(ctx, req.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.Status == entity.VideoStatusProcessing {
+		return fiber.NewError(http.StatusConflict, "Video is still being processed, try again once encoding has finished")
+	}
+
+	decodedDir, err := url.PathUnescape(video.Dir)
+	if err != nil || strings.Trim(decodedDir, "/") == "" {
+		log.Printf("[USECASE][DeleteVideo %s] invalid storage prefix %q", req.VideoID, video.Dir)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	objects, err := u.objectStore.ListObjects(ctx, strings.TrimSuffix(decodedDir, "/")+"/")
+	if err != nil {
+		log.Printf("[USECASE][ListObjects %s] %v", req.VideoID, err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+	for _, object := range objects {
+		if err := u.objectStore.RemoveObject(ctx, object.Key); err != nil {
+			log.Printf("[USECASE][RemoveObject %s] %v", req.VideoID, err)
+			return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+		}
+	}
+
+	if err := u.keyStore.Delete(ctx, req.VideoID); err != nil {
+		log.Printf("[USECASE][KeyStoreDelete %s] %v", req.VideoID, err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	if err := u.videoRepository.Delete(ctx, req.VideoID); err != nil && !errors.Is(err, repository.ErrVideoNotFound) {
+		log.Printf("[USECASE][DeleteVideo %s] %v", req.VideoID, err)
+		return fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	return nil
+}
+
+func toVideoResponse(video *entity.Video) *model.VideoResponse {
+	renditions := make([]model.VideoRenditionResponse, 0, len(video.Renditions))
+	for _, res := range video.Renditions {
+		renditions = append(renditions, model.VideoRenditionResponse{
+			Label:     res.Label,
+			Width:     res.Width,
+			Height:    res.Height,
+			Bandwidth: res.Bandwidth,
+			Codecs:    res.Codecs,
+		})
+	}
+
+	tags := video.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return &model.VideoResponse{
+		ID:               video.ID,
+		Title:            video.Title,
+		Description:      video.Description,
+		Tags:             tags,
+		Owner:            video.Owner,
+		OriginalFilename: video.OriginalFilename,
+		Status:           video.Status,
+		DurationMs:       video.DurationMs,
+		Renditions:       renditions,
+		CreatedAt:        video.CreatedAt,
+		UpdatedAt:        video.UpdatedAt,
+	}
+}
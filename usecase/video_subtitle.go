@@ -0,0 +1,205 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// subtitleGroupID is the GROUP-ID shared by every subtitles rendition in the
+// master playlist.
+const subtitleGroupID = "subs"
+
+// subtitleLanguage accepts BCP 47 style tags such as "en", "pt-BR" or "zh-Hant".
+var subtitleLanguage = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// UploadSubtitle converts an SRT or WebVTT file into an HLS subtitles
+// rendition segmented along the video segments and lists it in master.m3u8.
+// Uploading the same language again replaces the previous rendition.
+func (u *videoUseCase) UploadSubtitle(ctx context.Context, req *model.SubtitleUploadRequest) (*model.SubtitleResponse, error) {
+	if !subtitleLanguage.MatchString(req.Language) {
+		return nil, fiber.NewError(http.StatusBadRequest, "Invalid subtitle language, use a language tag such as en or pt-BR")
+	}
+	if req.Name == "" {
+		req.Name = req.Language
+	}
+	if strings.ContainsAny(req.Name, "\"\r\n") {
+		return nil, fiber.NewError(http.StatusBadRequest, "Invalid subtitle name")
+	}
+
+	cues, err := util.ParseSubtitles(req.Data)
+	if err != nil {
+		log.Printf("[USECASE][ParseSubtitles %s] %v", req.VideoID, err)
+		return nil, fiber.NewError(http.StatusUnprocessableEntity, fmt.Sprintf("Invalid subtitle file: %v", err))
+	}
+
+	// Two uploads for the same video would otherwise both rewrite the
+	// master they read and the later one would drop the other's rendition.
+	defer u.lockMaster(req.VideoID)()
+
+	decodedDir, master, err := u.readVideoObject(ctx, req.VideoID, "master.m3u8")
+	if err != nil {
+		return nil, err
+	}
+
+	reference := firstVariantPlaylist(string(master))
+	if reference == "" {
+		log.Printf("[USECASE][FirstVariantPlaylist %s] master playlist has no variants", req.VideoID)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	_, variant, err := u.readVideoObject(ctx, req.VideoID, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := util.ParseMediaPlaylist(variant)
+	if err != nil {
+		log.Printf("[USECASE][ParseMediaPlaylist %s] %v", req.VideoID, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	label := subtitleLabel(req.Language)
+	subtitleSegments := util.SegmentWebVTT(cues, segments, label)
+
+	objects := map[string][]byte{
+		fmt.Sprintf("%s.vtt", label):  util.FormatWebVTT(cues),
+		fmt.Sprintf("%s.m3u8", label): util.SubtitlePlaylist(subtitleSegments),
+	}
+	for _, segment := range subtitleSegments {
+		objects[segment.URI] = segment.Data
+	}
+
+	for name, data := range objects {
+		key := fmt.Sprintf("%s/%s", decodedDir, name)
+		if err := u.objectStore.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)), util.ObjectPutOptions(key)); err != nil {
+			log.Printf("[USECASE][PutObject %s] %v", key, err)
+			return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+		}
+	}
+
+	// The master goes last so players never see a rendition that is not
+	// fully uploaded.
+	updated := addSubtitleMedia(string(master), req.Name, req.Language, label, req.Default)
+	masterKey := fmt.Sprintf("%s/master.m3u8", decodedDir)
+	if err := u.objectStore.PutObject(ctx, masterKey, strings.NewReader(updated), int64(len(updated)), util.ObjectPutOptions(masterKey)); err != nil {
+		log.Printf("[USECASE][PutObject %s] %v", masterKey, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	return &model.SubtitleResponse{
+		VideoID:  req.VideoID,
+		Language: req.Language,
+		Name:     req.Name,
+		Playlist: fmt.Sprintf("%s.m3u8", label),
+		Segments: len(subtitleSegments),
+	}, nil
+}
+
+// masterLock is held while a video's master playlist is rewritten. refs
+// counts the holders and waiters so the lock can be dropped once unused.
+type masterLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockMaster locks the master playlist of a video and returns the unlock
+// function.
+func (u *videoUseCase) lockMaster(videoID string) func() {
+	u.masterMu.Lock()
+	lock := u.masterLocks[videoID]
+	if lock == nil {
+		lock = &masterLock{}
+		u.masterLocks[videoID] = lock
+	}
+	lock.refs++
+	u.masterMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		u.masterMu.Lock()
+		defer u.masterMu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(u.masterLocks, videoID)
+		}
+	}
+}
+
+func subtitleLabel(language string) string {
+	return "subs_" + strings.ToLower(language)
+}
+
+// firstVariantPlaylist returns the URI of the first variant in a master
+// playlist, used as the timeline the subtitles are segmented along.
+func firstVariantPlaylist(master string) string {
+	variant := false
+	for _, line := range strings.Split(master, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			variant = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		case variant:
+			return line
+		}
+	}
+	return ""
+}
+
+// addSubtitleMedia lists a subtitles rendition in a master playlist. An entry
+// for the same rendition is replaced, and every variant is pointed at the
+// subtitles group. A default rendition clears DEFAULT on the others.
+func addSubtitleMedia(master, name, language, label string, isDefault bool) string {
+	uri := fmt.Sprintf(`URI="%s.m3u8"`, label)
+	media := fmt.Sprintf(`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="%s",NAME="%s",LANGUAGE="%s"`, subtitleGroupID, name, language)
+	if isDefault {
+		media += ",DEFAULT=YES"
+	} else {
+		media += ",DEFAULT=NO"
+	}
+	media += ",AUTOSELECT=YES," + uri
+
+	lines := strings.Split(strings.TrimRight(master, "\n"), "\n")
+	out := make([]string, 0, len(lines)+1)
+	inserted := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "#EXT-X-MEDIA:TYPE=SUBTITLES") {
+			if strings.Contains(line, uri) {
+				continue
+			}
+			if isDefault {
+				line = strings.Replace(line, "DEFAULT=YES", "DEFAULT=NO", 1)
+			}
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			if !inserted {
+				out = append(out, media)
+				inserted = true
+			}
+			if !strings.Contains(line, "SUBTITLES=") {
+				line += fmt.Sprintf(`,SUBTITLES="%s"`, subtitleGroupID)
+			}
+		}
+
+		out = append(out, line)
+	}
+	if !inserted {
+		out = append(out, media)
+	}
+
+	return strings.Join(out, "\n") + "\n"
+}
@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/util"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IssuePlaybackToken signs a short-lived token that unlocks the keys of one
+// video for the user of a CMS session, optionally bound to the requesting IP
+// address.
+func (u *videoUseCase) IssuePlaybackToken(ctx context.Context, req *model.PlaybackTokenRequest) (*model.PlaybackTokenResponse, error) {
+	session, err := u.sessionSigner.Verify(req.Session, time.Now())
+	if err != nil {
+		return nil, fiber.NewError(http.StatusUnauthorized, "Valid session required")
+	}
+
+	if _, err := u.getVideo(ctx, req.VideoID); err != nil {
+		return nil, err
+	}
+
+	claims := util.PlaybackClaims{
+		VideoID: req.VideoID,
+		UserID:  session.UserID,
+	}
+	if req.BindIP {
+		claims.IP = req.ClientIP
+	}
+
+	token, expiresAt, err := u.tokenSigner.Sign(claims, time.Now())
+	if err != nil {
+		log.Printf("[USECASE][SignToken] %v", err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	return &model.PlaybackTokenResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// authorizePlayback verifies a playback token for a video. A missing, forged
+// or expired token is 401, a valid token for another video or IP is 403.
+func (u *videoUseCase) authorizePlayback(videoID, token, clientIP string) error {
+	if token == "" {
+		return fiber.NewError(http.StatusUnauthorized, "Playback token required")
+	}
+
+	claims, err := u.tokenSigner.Verify(token, time.Now())
+	if errors.Is(err, util.ErrTokenExpired) {
+		return fiber.NewError(http.StatusUnauthorized, "Playback token expired")
+	}
+	if err != nil {
+		return fiber.NewError(http.StatusUnauthorized, "Invalid playback token")
+	}
+
+	if claims.VideoID != videoID || (claims.IP != "" && claims.IP != clientIP) {
+		return fiber.NewError(http.StatusForbidden, "Playback token is not valid for this video")
+	}
+
+	return nil
+}
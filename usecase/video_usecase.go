@@ -1,127 +1,404 @@
 package usecase
 
 import (
+	"bytes"
 	"context"
+	"encoding/xml"
+	"errors"
+	"ffmpeg-hls/entity"
 	"ffmpeg-hls/model"
 	"ffmpeg-hls/repository"
 	"ffmpeg-hls/util"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/minio/minio-go/v7"
 )
 
 type VideoUseCase interface {
 	VideoManifest(ctx context.Context, req *model.VideoManifestRequest) ([]string, error)
 	VideoKey(ctx context.Context, req *model.VideoKeyRequest) ([]byte, error)
+	VideoDashManifest(ctx context.Context, req *model.VideoDashManifestRequest) ([]byte, error)
+	UploadSubtitle(ctx context.Context, req *model.SubtitleUploadRequest) (*model.SubtitleResponse, error)
+	VideoThumbnails(ctx context.Context, req *model.VideoThumbnailsRequest) ([]byte, error)
+	IssuePlaybackToken(ctx context.Context, req *model.PlaybackTokenRequest) (*model.PlaybackTokenResponse, error)
+	ListVideos(ctx context.Context, req *model.ListVideosRequest) ([]*model.VideoResponse, error)
+	GetVideo(ctx context.Context, req *model.GetVideoRequest) (*model.VideoResponse, error)
+	UpdateVideo(ctx context.Context, req *model.UpdateVideoRequest) (*model.VideoResponse, error)
+	DeleteVideo(ctx context.Context, req *model.DeleteVideoRequest) error
 }
 
 type videoUseCase struct {
-	minio           *util.Minio
+	objectStore     util.ObjectStore
 	videoRepository repository.VideoRepository
+	keyStore        repository.KeyStore
+	tokenSigner     *util.TokenSigner
+	sessionSigner   *util.SessionSigner
+	// masterMu guards masterLocks, which serialize the read-modify-write
+	// of a video's master playlist.
+	masterMu    sync.Mutex
+	masterLocks map[string]*masterLock
 }
 
-func NewVideoUseCase(minio *util.Minio, videoRepository repository.VideoRepository) VideoUseCase {
+func NewVideoUseCase(objectStore util.ObjectStore, videoRepository repository.VideoRepository, keyStore repository.KeyStore, tokenSigner *util.TokenSigner, sessionSigner *util.SessionSigner) VideoUseCase {
 	return &videoUseCase{
-		minio:           minio,
+		objectStore:     objectStore,
 		videoRepository: videoRepository,
+		keyStore:        keyStore,
+		tokenSigner:     tokenSigner,
+		sessionSigner:   sessionSigner,
+		masterLocks:     make(map[string]*masterLock),
 	}
 }
 
+// VideoManifest serves a playlist with presigned segment URLs. A playback
+// token passed with the request is checked and carried into every key and
+// playlist URI, since players only present the token to VideoKey that way.
 func (u *videoUseCase) VideoManifest(ctx context.Context, req *model.VideoManifestRequest) ([]string, error) {
-	video, err := u.videoRepository.GetByID(ctx, req.VideoID)
+	if req.Token != "" {
+		if err := u.authorizePlayback(req.VideoID, req.Token, req.ClientIP); err != nil {
+			return nil, err
+		}
+	}
+
+	decodedDir, raw, err := u.readVideoObject(ctx, req.VideoID, req.Playlist)
 	if err != nil {
-		log.Print(fmt.Sprint("[CLIENT][[USECASE][GetById] error : %w", err))
-		return nil, fiber.NewError(http.StatusNotFound, "Requested video not found")
+		return nil, err
 	}
 
-	decodedDir, err := url.PathUnescape(video.Dir)
+	lines, err := rewritePlaylist(string(raw), u.presigner(ctx, decodedDir), req.Token)
+	if err != nil {
+		log.Printf("[USECASE][VideoManifest %s] presign: %v", req.VideoID, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	return lines, nil
+}
+
+func (u *videoUseCase) VideoDashManifest(ctx context.Context, req *model.VideoDashManifestRequest) ([]byte, error) {
+	decodedDir, raw, err := u.readVideoObject(ctx, req.VideoID, fmt.Sprintf("%s.mpd", req.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := rewriteDashManifest(raw, u.presigner(ctx, decodedDir))
 	if err != nil {
-		log.Print(fmt.Sprint("[INTERNAL][[USECASE][DecodeUrl] error : %w", err))
+		log.Printf("[USECASE][VideoDashManifest %s] presign: %v", req.VideoID, err)
 		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
 	}
 
-	key := fmt.Sprintf("%s/%s", decodedDir, req.Playlist)
-	obj, err := u.minio.GetObject(ctx, u.minio.GetBucketName(), key, minio.GetObjectOptions{})
+	return manifest, nil
+}
+
+func (u *videoUseCase) VideoThumbnails(ctx context.Context, req *model.VideoThumbnailsRequest) ([]byte, error) {
+	decodedDir, raw, err := u.readVideoObject(ctx, req.VideoID, thumbnailTrack)
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := rewriteThumbnailTrack(raw, u.presigner(ctx, decodedDir))
 	if err != nil {
-		log.Print(fmt.Sprint("[INTERNAL][[USECASE][GetObject] error : %w", err))
+		log.Printf("[USECASE][VideoThumbnails %s] presign: %v", req.VideoID, err)
 		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
 	}
 
-	log.Println("ini video dir", video.Dir)
-	log.Println("ini key", key)
+	return track, nil
+}
 
-	log.Println("ini playlist", req.Playlist)
+// getVideo loads the video record, mapping a missing video to 404.
+func (u *videoUseCase) getVideo(ctx context.Context, videoID string) (*entity.Video, error) {
+	video, err := u.videoRepository.GetByID(ctx, videoID)
+	if errors.Is(err, repository.ErrVideoNotFound) {
+		return nil, fiber.NewError(http.StatusNotFound, "Requested video not found")
+	}
+	if err != nil {
+		log.Printf("[USECASE][GetById %s] %v", videoID, err)
+		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
 
+	return video, nil
+}
+
+// readVideoObject loads a file stored under the video directory and returns
+// it together with the decoded directory.
+func (u *videoUseCase) readVideoObject(ctx context.Context, videoID, name string) (string, []byte, error) {
+	video, err := u.getVideo(ctx, videoID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	decodedDir, err := url.PathUnescape(video.Dir)
+	if err != nil {
+		log.Printf("[USECASE][DecodeUrl %s] %v", videoID, err)
+		return "", nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
+
+	key := fmt.Sprintf("%s/%s", decodedDir, name)
+	obj, err := u.objectStore.GetObject(ctx, key)
+	if errors.Is(err, util.ErrObjectNotFound) {
+		log.Printf("[USECASE][GetObject %s] %v", key, err)
+		return "", nil, fiber.NewError(http.StatusNotFound, "Requested file not found")
+	}
+	if err != nil {
+		log.Printf("[USECASE][GetObject %s] %v", key, err)
+		return "", nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+	}
 	defer obj.Close()
 
 	raw, err := io.ReadAll(obj)
 	if err != nil {
-		log.Print(fmt.Sprint("[INTERNAL][[USECASE][IoReadAll] error : %w", err))
-		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+		log.Printf("[USECASE][ReadObject %s] %v", key, err)
+		return "", nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
 	}
 
-	lines := strings.Split(string(raw), "\n")
+	return decodedDir, raw, nil
+}
+
+// presigner returns a function that presigns objects in the video directory.
+func (u *videoUseCase) presigner(ctx context.Context, decodedDir string) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		return u.objectStore.PresignedGetObject(ctx, fmt.Sprintf("%s/%s", decodedDir, name), time.Hour)
+	}
+}
+
+// segmentExtensions are the media files served straight from the bucket.
+var segmentExtensions = []string{".ts", ".m4s", ".mp4", ".vtt"}
+
+// rewritePlaylist replaces segment references, including the init segment in
+// #EXT-X-MAP, with URLs returned by presign. Variant playlists and key URIs
+// stay relative to this API and carry the playback token, if any, so that
+// the player presents it on every follow-up request. I-frame playlists list
+// the same segment once per #EXT-X-BYTERANGE, so each segment is presigned
+// once and the byte range tags are kept as they are.
+func rewritePlaylist(raw string, presign func(name string) (string, error), token string) ([]string, error) {
+	presign = memoizePresign(presign)
+	presignURI := func(uri string) (string, error) {
+		return presign(filepath.Base(uri))
+	}
+	tokenURI := func(uri string) (string, error) {
+		return withToken(uri, token), nil
+	}
+
+	lines := strings.Split(raw, "\n")
 	for i, rawLine := range lines {
 		trimmed := strings.TrimSpace(rawLine)
+		lines[i] = trimmed
 
-		if strings.HasPrefix(trimmed, "#EXT-X-KEY") {
-			log.Println("ini adalah trimmed ", trimmed)
-			lines[i] = trimmed
-			continue
+		var err error
+		switch {
+		case strings.HasPrefix(trimmed, "#EXT-X-BYTERANGE"):
+		case strings.HasPrefix(trimmed, "#EXT-X-KEY:"):
+			lines[i], err = replaceURIAttribute(trimmed, tokenURI)
+		case strings.HasPrefix(trimmed, "#EXT-X-MAP:"):
+			lines[i], err = replaceURIAttribute(trimmed, presignURI)
+		case strings.HasPrefix(trimmed, "#EXT-X-MEDIA:"), strings.HasPrefix(trimmed, "#EXT-X-I-FRAME-STREAM-INF:"):
+			lines[i], err = replaceURIAttribute(trimmed, tokenURI)
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			lines[i] = rawLine
+		case isSegment(trimmed):
+			lines[i], err = presignURI(trimmed)
+		case strings.HasSuffix(trimmed, ".m3u8"):
+			lines[i] = withToken(trimmed, token)
 		}
-
-		if strings.HasSuffix(trimmed, ".ts") {
-			seg := filepath.Base(trimmed)
-			decodedDir, err := url.PathUnescape(video.Dir)
-			url, err := u.minio.PresignedGetObject(ctx, u.minio.GetBucketName(), fmt.Sprintf("%s/%s", decodedDir, seg), time.Hour, nil)
-			if err != nil {
-				log.Print(fmt.Sprint("[INTERNAL][USECASE][PresignedGetObject] error : %w", err))
-				return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
-			}
-			lines[i] = url.String()
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	return lines, nil
 }
 
+// withToken adds the playback token to a URI served by this API.
+func withToken(uri, token string) string {
+	if token == "" {
+		return uri
+	}
+
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+	return uri + separator + "token=" + url.QueryEscape(token)
+}
+
+// memoizePresign caches presigned URLs by object name.
+func memoizePresign(presign func(name string) (string, error)) func(name string) (string, error) {
+	urls := make(map[string]string)
+	return func(name string) (string, error) {
+		if url, ok := urls[name]; ok {
+			return url, nil
+		}
+
+		url, err := presign(name)
+		if err != nil {
+			return "", err
+		}
+		urls[name] = url
+		return url, nil
+	}
+}
+
+// replaceURIAttribute rewrites the quoted URI attribute of a playlist tag.
+func replaceURIAttribute(tag string, rewrite func(uri string) (string, error)) (string, error) {
+	start := strings.Index(tag, `URI="`)
+	if start < 0 {
+		return tag, nil
+	}
+	start += len(`URI="`)
+
+	end := strings.Index(tag[start:], `"`)
+	if end < 0 {
+		return tag, nil
+	}
+	end += start
+
+	uri, err := rewrite(tag[start:end])
+	if err != nil {
+		return "", err
+	}
+
+	return tag[:start] + uri + tag[end:], nil
+}
+
+func isSegment(name string) bool {
+	for _, ext := range segmentExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
 func (u *videoUseCase) VideoKey(ctx context.Context, req *model.VideoKeyRequest) ([]byte, error) {
-	video, err := u.videoRepository.GetByID(ctx, req.VideoID)
+	if err := u.authorizePlayback(req.VideoID, req.Token, req.ClientIP); err != nil {
+		return nil, err
+	}
+
+	if !isKeyName(req.KeyName) {
+		return nil, fiber.NewError(http.StatusNotFound, "Requested key not found")
+	}
+
+	video, err := u.getVideo(ctx, req.VideoID)
 	if err != nil {
-		log.Print(fmt.Sprint("[CLIENT][[USECASE][GetById] error : %w", err))
-		return nil, fiber.NewError(http.StatusNotFound, "Requested video not found")
+		return nil, err
 	}
 
-	decodedDir, err := url.PathUnescape(video.Dir)
+	data, err := u.keyStore.Get(ctx, req.VideoID, req.KeyName)
+	if errors.Is(err, repository.ErrKeyNotFound) {
+		data, err = u.importLegacyKey(ctx, video, req.VideoID, req.KeyName)
+	}
+	if errors.Is(err, repository.ErrKeyNotFound) {
+		return nil, fiber.NewError(http.StatusNotFound, "Requested key not found")
+	}
 	if err != nil {
-		log.Print(fmt.Sprint("[INTERNAL][[USECASE][DecodeUrl] error : %w", err))
+		log.Printf("[USECASE][KeyStoreGet %s] %v", req.VideoID, err)
 		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
 	}
 
-	keyPath := fmt.Sprintf("%s/secrets/%s", decodedDir, req.KeyName)
-	log.Println("KeyPath:", keyPath)
+	return data, nil
+}
 
-	obj, err := u.minio.GetObject(ctx, u.minio.GetBucketName(), keyPath, minio.GetObjectOptions{})
+// importLegacyKey moves a key uploaded in plaintext to the secrets/ folder of
+// the bucket by older encodes into the key store, then removes it from the
+// bucket.
+func (u *videoUseCase) importLegacyKey(ctx context.Context, video *entity.Video, videoID, name string) ([]byte, error) {
+	decodedDir, err := url.PathUnescape(video.Dir)
 	if err != nil {
-		log.Print(fmt.Sprint("[INTERNAL][[USECASE][GetObject] error : %w", err))
-		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+		return nil, err
 	}
 
+	keyPath := fmt.Sprintf("%s/secrets/%s", decodedDir, name)
+	obj, err := u.objectStore.GetObject(ctx, keyPath)
+	if errors.Is(err, util.ErrObjectNotFound) {
+		return nil, repository.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
 	data, err := io.ReadAll(obj)
 	if err != nil {
-		log.Print("Failed to read all from object:", err)
-		log.Print(fmt.Sprint("[INTERNAL][[USECASE][IoReadAll] error : %w", err))
-		return nil, fiber.NewError(http.StatusInternalServerError, "Something wrong please try again later.")
+		return nil, err
+	}
 
+	if err := u.keyStore.Put(ctx, videoID, name, data); err != nil {
+		return nil, err
+	}
+	if err := u.objectStore.RemoveObject(ctx, keyPath); err != nil {
+		log.Printf("[USECASE][RemoveObject %s] %v", keyPath, err)
 	}
 
 	return data, nil
 }
+
+// isKeyName reports whether name looks like a key written by the encoder,
+// either enc_<label>.key or enc_<label>_<period>.key with rotation.
+func isKeyName(name string) bool {
+	return strings.HasPrefix(name, "enc_") && strings.HasSuffix(name, ".key") && !strings.ContainsAny(name, "/\\") && !strings.Contains(name, "..")
+}
+
+var dashURLAttribute = regexp.MustCompile(`\b(sourceURL|media)="([^"]+)"`)
+
+// rewriteDashManifest replaces the initialization and media segment URLs of an
+// MPD with URLs returned by presign.
+func rewriteDashManifest(raw []byte, presign func(name string) (string, error)) ([]byte, error) {
+	var presignErr error
+	rewritten := dashURLAttribute.ReplaceAllFunc(raw, func(match []byte) []byte {
+		if presignErr != nil {
+			return match
+		}
+
+		parts := dashURLAttribute.FindSubmatch(match)
+		name := html.UnescapeString(string(parts[2]))
+
+		url, err := presign(filepath.Base(name))
+		if err != nil {
+			presignErr = err
+			return match
+		}
+
+		var escaped bytes.Buffer
+		xml.EscapeText(&escaped, []byte(url))
+		return []byte(fmt.Sprintf(`%s="%s"`, parts[1], escaped.String()))
+	})
+	if presignErr != nil {
+		return nil, presignErr
+	}
+
+	return rewritten, nil
+}
+
+// rewriteThumbnailTrack replaces the sprite sheet of every cue with a URL
+// returned by presign, keeping the #xywh= fragment. Sheets are presigned once
+// since many cues share the same sheet.
+func rewriteThumbnailTrack(raw []byte, presign func(name string) (string, error)) ([]byte, error) {
+	presign = memoizePresign(presign)
+
+	lines := strings.Split(string(raw), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		sheet, fragment, ok := strings.Cut(line, "#xywh=")
+		if !ok || strings.Contains(line, "-->") {
+			continue
+		}
+
+		url, err := presign(filepath.Base(sheet))
+		if err != nil {
+			return nil, err
+		}
+
+		lines[i] = url + "#xywh=" + fragment
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
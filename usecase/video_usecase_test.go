@@ -0,0 +1,475 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-hls/entity"
+	"ffmpeg-hls/model"
+	"ffmpeg-hls/repository"
+	"ffmpeg-hls/util"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func presignForTest(name string) (string, error) {
+	return "https://bucket/" + name + "?sig=1", nil
+}
+
+func TestRewritePlaylistTS(t *testing.T) {
+	raw := "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"http://api/videos/v/keys/enc_360p.key\",IV=0x01  \n#EXTINF:4.000000,\n360p_000.ts\n#EXT-X-ENDLIST"
+
+	lines, err := rewritePlaylist(raw, presignForTest, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"http://api/videos/v/keys/enc_360p.key\",IV=0x01\n#EXTINF:4.000000,\nhttps://bucket/360p_000.ts?sig=1\n#EXT-X-ENDLIST"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("unexpected playlist:\n%s", got)
+	}
+}
+
+func TestRewritePlaylistFMP4(t *testing.T) {
+	raw := "#EXTM3U\n#EXT-X-MAP:URI=\"720p_init.mp4\"\n#EXTINF:4.000000,\n720p_000.m4s\n"
+
+	lines, err := rewritePlaylist(raw, presignForTest, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(lines, "\n")
+	if !strings.Contains(got, "#EXT-X-MAP:URI=\"https://bucket/720p_init.mp4?sig=1\"") {
+		t.Errorf("init segment not presigned:\n%s", got)
+	}
+	if !strings.Contains(got, "\nhttps://bucket/720p_000.m4s?sig=1\n") {
+		t.Errorf("m4s segment not presigned:\n%s", got)
+	}
+}
+
+func TestRewritePlaylistMaster(t *testing.T) {
+	raw := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n360p.m3u8\n"
+
+	lines, err := rewritePlaylist(raw, presignForTest, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(lines, "\n"); got != raw {
+		t.Fatalf("master playlist should be unchanged:\n%s", got)
+	}
+}
+
+func TestRewriteDashManifest(t *testing.T) {
+	raw := []byte(`<SegmentList timescale="1000"><Initialization sourceURL="720p_init.mp4"></Initialization><SegmentURL media="720p_000.m4s"></SegmentURL></SegmentList>`)
+
+	rewritten, err := rewriteDashManifest(raw, func(name string) (string, error) {
+		return "https://bucket/" + name + "?a=1&b=2", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<SegmentList timescale="1000"><Initialization sourceURL="https://bucket/720p_init.mp4?a=1&amp;b=2"></Initialization><SegmentURL media="https://bucket/720p_000.m4s?a=1&amp;b=2"></SegmentURL></SegmentList>`
+	if string(rewritten) != want {
+		t.Fatalf("unexpected manifest:\n%s", rewritten)
+	}
+}
+
+func TestAddSubtitleMedia(t *testing.T) {
+	master := "#EXTM3U\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"English\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio_0.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,CODECS=\"avc1.64001e,mp4a.40.2\",AUDIO=\"audio\"\n360p.m3u8\n"
+
+	if got := firstVariantPlaylist(master); got != "360p.m3u8" {
+		t.Fatalf("unexpected reference playlist %q", got)
+	}
+
+	withEnglish := addSubtitleMedia(master, "English", "en", "subs_en", true)
+	want := "#EXTM3U\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"English\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio_0.m3u8\"\n" +
+		"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"English\",LANGUAGE=\"en\",DEFAULT=YES,AUTOSELECT=YES,URI=\"subs_en.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,CODECS=\"avc1.64001e,mp4a.40.2\",AUDIO=\"audio\",SUBTITLES=\"subs\"\n360p.m3u8\n"
+	if withEnglish != want {
+		t.Fatalf("unexpected master playlist:\n%s", withEnglish)
+	}
+
+	withFrench := addSubtitleMedia(withEnglish, "Français", "fr", "subs_fr", true)
+	if strings.Count(withFrench, "SUBTITLES=\"subs\"") != 1 || strings.Count(withFrench, "DEFAULT=YES") != 2 ||
+		!strings.Contains(withFrench, "LANGUAGE=\"en\",DEFAULT=NO") {
+		t.Fatalf("unexpected master playlist after second language:\n%s", withFrench)
+	}
+
+	replaced := addSubtitleMedia(withFrench, "English (CC)", "en", "subs_en", false)
+	if strings.Count(replaced, "URI=\"subs_en.m3u8\"") != 1 || !strings.Contains(replaced, "NAME=\"English (CC)\"") {
+		t.Fatalf("expected the English rendition to be replaced:\n%s", replaced)
+	}
+}
+
+// slowMasterStore delays master playlist reads so that concurrent rewrites
+// would overlap without a lock.
+type slowMasterStore struct {
+	util.ObjectStore
+}
+
+func (s *slowMasterStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	if strings.HasSuffix(key, "/master.m3u8") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s.ObjectStore.GetObject(ctx, key)
+}
+
+func TestUploadSubtitleConcurrent(t *testing.T) {
+	u, store, _ := newManageTestUseCase(t)
+	u.objectStore = &slowMasterStore{store}
+	ctx := context.Background()
+
+	if err := u.videoRepository.Save(ctx, &entity.Video{ID: "vid", Dir: "courses/vid", Status: entity.VideoStatusReady}); err != nil {
+		t.Fatal(err)
+	}
+	objects := map[string]string{
+		"courses/vid/master.m3u8": "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n360p.m3u8\n",
+		"courses/vid/360p.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.000000,\n360p_000.ts\n#EXT-X-ENDLIST\n",
+	}
+	for key, data := range objects {
+		if err := store.PutObject(ctx, key, strings.NewReader(data), int64(len(data)), util.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	languages := []string{"en", "fr", "de", "es", "it", "pt", "ja", "ko"}
+	errs := make(chan error, len(languages))
+	for _, language := range languages {
+		go func(language string) {
+			_, err := u.UploadSubtitle(ctx, &model.SubtitleUploadRequest{
+				VideoID:  "vid",
+				Language: language,
+				Data:     []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"),
+			})
+			errs <- err
+		}(language)
+	}
+	for range languages {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, master, err := u.readVideoObject(ctx, "vid", "master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, language := range languages {
+		if !strings.Contains(string(master), fmt.Sprintf(`URI="subs_%s.m3u8"`, language)) {
+			t.Fatalf("master playlist lost the %s rendition:\n%s", language, master)
+		}
+	}
+	if len(u.masterLocks) != 0 {
+		t.Fatalf("got %d master locks left, want none", len(u.masterLocks))
+	}
+}
+
+func TestRewriteThumbnailTrack(t *testing.T) {
+	raw := []byte("WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nthumbs_000.jpg#xywh=0,0,160,90\n\n00:00:05.000 --> 00:00:10.000\nthumbs_000.jpg#xywh=160,0,160,90\n")
+
+	calls := 0
+	track, err := rewriteThumbnailTrack(raw, func(name string) (string, error) {
+		calls++
+		return presignForTest(name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nhttps://bucket/thumbs_000.jpg?sig=1#xywh=0,0,160,90\n\n00:00:05.000 --> 00:00:10.000\nhttps://bucket/thumbs_000.jpg?sig=1#xywh=160,0,160,90\n"
+	if string(track) != want {
+		t.Fatalf("unexpected track:\n%s", track)
+	}
+	if calls != 1 {
+		t.Fatalf("expected one presign per sheet, got %d", calls)
+	}
+}
+
+func TestRewritePlaylistByteRange(t *testing.T) {
+	raw := "#EXTM3U\n#EXT-X-I-FRAMES-ONLY\n#EXT-X-MAP:URI=\"720p_001.ts\",BYTERANGE=\"376@0\"\n#EXTINF:2.000000,\n#EXT-X-BYTERANGE:40984@376 \n720p_001.ts\n#EXTINF:2.000000,\n#EXT-X-BYTERANGE:40000@200000\n720p_001.ts\n"
+
+	calls := 0
+	lines, err := rewritePlaylist(raw, func(name string) (string, error) {
+		calls++
+		return presignForTest(name)
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "#EXTM3U\n#EXT-X-I-FRAMES-ONLY\n#EXT-X-MAP:URI=\"https://bucket/720p_001.ts?sig=1\",BYTERANGE=\"376@0\"\n#EXTINF:2.000000,\n#EXT-X-BYTERANGE:40984@376\nhttps://bucket/720p_001.ts?sig=1\n#EXTINF:2.000000,\n#EXT-X-BYTERANGE:40000@200000\nhttps://bucket/720p_001.ts?sig=1\n"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("unexpected playlist:\n%s", got)
+	}
+	if calls != 1 {
+		t.Fatalf("expected the segment to be presigned once, got %d", calls)
+	}
+}
+
+func TestRewritePlaylistToken(t *testing.T) {
+	master := "#EXTM3U\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"English\",URI=\"audio_0.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO=\"audio\"\n360p.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=90000,URI=\"360p_iframes.m3u8\"\n"
+
+	lines, err := rewritePlaylist(master, presignForTest, "a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{`URI="audio_0.m3u8?token=a.b"`, "\n360p.m3u8?token=a.b\n", `URI="360p_iframes.m3u8?token=a.b"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("master missing %s:\n%s", want, got)
+		}
+	}
+
+	variant := "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"http://api/videos/v/keys/enc_360p.key\",IV=0x01\n#EXTINF:4.000000,\n360p_000.ts\n"
+	lines, err = rewritePlaylist(variant, presignForTest, "a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(lines, "\n"); !strings.Contains(got, `URI="http://api/videos/v/keys/enc_360p.key?token=a.b",IV=0x01`) {
+		t.Fatalf("token not added to key URI:\n%s", got)
+	}
+}
+
+func TestAuthorizePlayback(t *testing.T) {
+	signer := util.NewTokenSigner([]byte("secret"), time.Hour)
+	u := &videoUseCase{tokenSigner: signer}
+
+	token, _, err := signer.Sign(util.PlaybackClaims{VideoID: "vid", IP: "10.0.0.1"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := signer.Sign(util.PlaybackClaims{VideoID: "vid"}, time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		videoID  string
+		token    string
+		clientIP string
+		status   int
+	}{
+		{"valid", "vid", token, "10.0.0.1", 0},
+		{"missing", "vid", "", "10.0.0.1", http.StatusUnauthorized},
+		{"forged", "vid", token + "x", "10.0.0.1", http.StatusUnauthorized},
+		{"expired", "vid", expired, "10.0.0.1", http.StatusUnauthorized},
+		{"other video", "other", token, "10.0.0.1", http.StatusForbidden},
+		{"other ip", "vid", token, "10.0.0.2", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := u.authorizePlayback(tt.videoID, tt.token, tt.clientIP)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			expectStatus(t, err, tt.status)
+		})
+	}
+}
+
+func TestIssuePlaybackToken(t *testing.T) {
+	u, _, _ := newManageTestUseCase(t)
+	ctx := context.Background()
+	u.tokenSigner = util.NewTokenSigner([]byte("secret"), time.Hour)
+	u.sessionSigner = util.NewSessionSigner([]byte("shared"))
+
+	if err := u.videoRepository.Save(ctx, &entity.Video{ID: "vid", Status: entity.VideoStatusReady}); err != nil {
+		t.Fatal(err)
+	}
+	session, err := u.sessionSigner.Sign("u1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := util.NewSessionSigner([]byte("guess")).Sign("u1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		videoID string
+		session string
+		status  int
+	}{
+		{"anonymous", "vid", "", http.StatusUnauthorized},
+		{"forged session", "vid", forged, http.StatusUnauthorized},
+		{"unknown video", "missing", session, http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := u.IssuePlaybackToken(ctx, &model.PlaybackTokenRequest{VideoID: tt.videoID, Session: tt.session})
+			expectStatus(t, err, tt.status)
+		})
+	}
+
+	response, err := u.IssuePlaybackToken(ctx, &model.PlaybackTokenRequest{VideoID: "vid", Session: session, BindIP: true, ClientIP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := u.tokenSigner.Verify(response.Token, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.VideoID != "vid" || claims.UserID != "u1" || claims.IP != "10.0.0.1" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func newManageTestUseCase(t *testing.T) (*videoUseCase, util.ObjectStore, repository.KeyStore) {
+	t.Helper()
+
+	store := newTestStore(t)
+	db := openTestDB(t, store)
+	keyStore := repository.NewFileKeyStore(t.TempDir())
+	u := &videoUseCase{
+		objectStore:     store,
+		videoRepository: repository.NewVideoRepository(db),
+		keyStore:        keyStore,
+		masterLocks:     make(map[string]*masterLock),
+	}
+	return u, store, keyStore
+}
+
+func TestVideoKeyLegacy(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	legacy := map[string]string{
+		"courses/legacy/master.m3u8":          "#EXTM3U\n",
+		"courses/legacy/secrets/enc_360p.key": "0123456789abcdef",
+	}
+	for key, data := range legacy {
+		if err := store.PutObject(ctx, key, strings.NewReader(data), int64(len(data)), util.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := openTestDB(t, store)
+	signer := util.NewTokenSigner([]byte("secret"), time.Hour)
+	u := &videoUseCase{
+		objectStore:     store,
+		videoRepository: repository.NewVideoRepository(db),
+		keyStore:        repository.NewFileKeyStore(t.TempDir()),
+		tokenSigner:     signer,
+	}
+	token, _, err := signer.Sign(util.PlaybackClaims{VideoID: "legacy"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request moves the key out of the bucket, later ones read it
+	// from the key store.
+	for i := 0; i < 2; i++ {
+		key, err := u.VideoKey(ctx, &model.VideoKeyRequest{VideoID: "legacy", KeyName: "enc_360p.key", Token: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(key) != "0123456789abcdef" {
+			t.Fatalf("got key %q", key)
+		}
+	}
+	if _, err := store.StatObject(ctx, "courses/legacy/secrets/enc_360p.key"); !errors.Is(err, util.ErrObjectNotFound) {
+		t.Fatalf("got %v, want the legacy key removed from the bucket", err)
+	}
+}
+
+func TestUpdateVideo(t *testing.T) {
+	u, _, _ := newManageTestUseCase(t)
+	ctx := context.Background()
+
+	if err := u.videoRepository.Save(ctx, &entity.Video{ID: "vid", Title: "Lesson 1", Status: entity.VideoStatusReady}); err != nil {
+		t.Fatal(err)
+	}
+
+	title, tags := "  Lesson 1: Basics ", []string{"go", " intro", "go"}
+	response, err := u.UpdateVideo(ctx, &model.UpdateVideoRequest{VideoID: "vid", Title: &title, Tags: &tags})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Title != "Lesson 1: Basics" || !reflect.DeepEqual(response.Tags, []string{"go", "intro"}) {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	description := "Variables and types"
+	response, err = u.UpdateVideo(ctx, &model.UpdateVideoRequest{VideoID: "vid", Description: &description})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Title != "Lesson 1: Basics" || response.Description != description || len(response.Tags) != 2 {
+		t.Fatalf("absent fields should be kept: %+v", response)
+	}
+
+	empty := " "
+	tests := []struct {
+		name   string
+		req    *model.UpdateVideoRequest
+		status int
+	}{
+		{"empty title", &model.UpdateVideoRequest{VideoID: "vid", Title: &empty}, http.StatusBadRequest},
+		{"empty tag", &model.UpdateVideoRequest{VideoID: "vid", Tags: &[]string{""}}, http.StatusBadRequest},
+		{"missing video", &model.UpdateVideoRequest{VideoID: "missing", Description: &description}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := u.UpdateVideo(ctx, tt.req)
+			expectStatus(t, err, tt.status)
+		})
+	}
+}
+
+func TestDeleteVideo(t *testing.T) {
+	u, store, keyStore := newManageTestUseCase(t)
+	ctx := context.Background()
+
+	for _, video := range []*entity.Video{
+		{ID: "vid", Dir: "courses/vid", Status: entity.VideoStatusReady},
+		{ID: "vid2", Dir: "courses/vid2", Status: entity.VideoStatusReady},
+		{ID: "busy", Dir: "courses/busy", Status: entity.VideoStatusProcessing},
+	} {
+		if err := u.videoRepository.Save(ctx, video); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"courses/vid/master.m3u8", "courses/vid/360p_000.ts", "courses/vid/secrets/enc_360p.key", "courses/vid2/master.m3u8"} {
+		if err := store.PutObject(ctx, key, strings.NewReader("x"), 1, util.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := keyStore.Put(ctx, "vid", "enc_360p.key", []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := u.DeleteVideo(ctx, &model.DeleteVideoRequest{VideoID: "busy"})
+	expectStatus(t, err, http.StatusConflict)
+
+	if err := u.DeleteVideo(ctx, &model.DeleteVideoRequest{VideoID: "vid"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if objects, _ := store.ListObjects(ctx, "courses/"); len(objects) != 1 || objects[0].Key != "courses/vid2/master.m3u8" {
+		t.Fatalf("unexpected remaining objects %+v", objects)
+	}
+	if _, err := keyStore.Get(ctx, "vid", "enc_360p.key"); !errors.Is(err, repository.ErrKeyNotFound) {
+		t.Fatalf("content key not deleted: %v", err)
+	}
+	if _, err := u.videoRepository.GetByID(ctx, "vid"); !errors.Is(err, repository.ErrVideoNotFound) {
+		t.Fatalf("record not deleted: %v", err)
+	}
+
+	err = u.DeleteVideo(ctx, &model.DeleteVideoRequest{VideoID: "vid"})
+	expectStatus(t, err, http.StatusNotFound)
+}
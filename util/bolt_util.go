@@ -0,0 +1,30 @@
+package util
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// InitBolt opens the embedded BoltDB database used to persist service state.
+func InitBolt() *bolt.DB {
+	path := os.Getenv("BOLT_DB_PATH")
+	if path == "" {
+		path = filepath.Join("data", "ffmpeg-hls.db")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Fatalf("Failed to create database directory: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	log.Printf("Opened database: %s", path)
+	return db
+}
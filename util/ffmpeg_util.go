@@ -0,0 +1,470 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FFmpegProgress is one block of key=value pairs emitted by `ffmpeg -progress`.
+type FFmpegProgress struct {
+	OutTime time.Duration
+	FPS     float64
+	Speed   float64
+	Done    bool // true on the final "progress=end" block
+}
+
+// MediaInfo describes the first video stream of a source file.
+type MediaInfo struct {
+	Width    int // coded width in pixels
+	Height   int // coded height in pixels
+	Rotation int // clockwise display rotation in degrees: 0, 90, 180 or 270
+	SAR      float64
+	FPS      float64
+	Duration time.Duration
+	HasAudio bool
+
+	AudioStreams []AudioStream
+}
+
+// AudioStream describes one audio stream of the source, in input order.
+type AudioStream struct {
+	Index    int    // position among the audio streams, as in -map 0:a:<Index>
+	Language string // ISO 639 code from the stream metadata, if any
+	Title    string
+	Channels int
+	Default  bool
+}
+
+// DisplaySize returns the frame size as presented to the viewer, after
+// applying the sample aspect ratio and rotation.
+func (m *MediaInfo) DisplaySize() (int, int) {
+	width, height := m.Width, m.Height
+	if m.SAR > 0 && m.SAR != 1 {
+		width = int(math.Round(float64(width) * m.SAR))
+	}
+	if m.Rotation == 90 || m.Rotation == 270 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType         string            `json:"codec_type"`
+		CodecName         string            `json:"codec_name"`
+		Profile           string            `json:"profile"`
+		Level             int               `json:"level"`
+		PixFmt            string            `json:"pix_fmt"`
+		Width             int               `json:"width"`
+		Height            int               `json:"height"`
+		SampleAspectRatio string            `json:"sample_aspect_ratio"`
+		RFrameRate        string            `json:"r_frame_rate"`
+		AvgFrameRate      string            `json:"avg_frame_rate"`
+		Duration          string            `json:"duration"`
+		Channels          int               `json:"channels"`
+		Tags              map[string]string `json:"tags"`
+		Disposition       map[string]int    `json:"disposition"`
+		SideDataList      []struct {
+			SideDataType string  `json:"side_data_type"`
+			Rotation     float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// ProbeMedia runs ffprobe on the input and returns its video stream properties.
+func ProbeMedia(ctx context.Context, inputPath string) (*MediaInfo, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		inputPath,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	return ParseProbeOutput(out)
+}
+
+// ParseProbeOutput extracts MediaInfo from `ffprobe -print_format json` output.
+func ParseProbeOutput(data []byte) (*MediaInfo, error) {
+	var probe ffprobeOutput
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("decode ffprobe output: %w", err)
+	}
+
+	var audioStreams []AudioStream
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+
+		audio := AudioStream{
+			Index:    len(audioStreams),
+			Title:    stream.Tags["title"],
+			Channels: stream.Channels,
+			Default:  stream.Disposition["default"] == 1,
+		}
+		if language := stream.Tags["language"]; language != "und" {
+			audio.Language = language
+		}
+		audioStreams = append(audioStreams, audio)
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		if stream.Width <= 0 || stream.Height <= 0 {
+			return nil, fmt.Errorf("video stream has invalid size %dx%d", stream.Width, stream.Height)
+		}
+
+		info := &MediaInfo{
+			AudioStreams: audioStreams,
+			Width:        stream.Width,
+			Height:       stream.Height,
+			SAR:          parseRatio(stream.SampleAspectRatio, ":"),
+			FPS:          parseRatio(stream.AvgFrameRate, "/"),
+			HasAudio:     len(audioStreams) > 0,
+		}
+		if info.FPS == 0 {
+			info.FPS = parseRatio(stream.RFrameRate, "/")
+		}
+
+		// Older files carry a rotate tag, newer ffprobe reports a display
+		// matrix whose rotation is counter-clockwise.
+		rotation := 0.0
+		if tag, ok := stream.Tags["rotate"]; ok {
+			rotation, _ = strconv.ParseFloat(tag, 64)
+		}
+		for _, side := range stream.SideDataList {
+			if side.SideDataType == "Display Matrix" {
+				rotation = -side.Rotation
+			}
+		}
+		info.Rotation = ((int(math.Round(rotation)) % 360) + 360) % 360
+
+		duration := probe.Format.Duration
+		if duration == "" {
+			duration = stream.Duration
+		}
+		if seconds, err := strconv.ParseFloat(duration, 64); err == nil {
+			info.Duration = time.Duration(seconds * float64(time.Second))
+		}
+
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("no video stream found")
+}
+
+// ParseEncoders extracts encoder names from `ffmpeg -encoders` output.
+func ParseEncoders(data []byte) map[string]bool {
+	encoders := make(map[string]bool)
+	listing := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !listing {
+			listing = strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+// InitEncoders lists the encoders compiled into the local ffmpeg build.
+func InitEncoders() map[string]bool {
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		log.Fatalf("Failed to list ffmpeg encoders: %v", err)
+	}
+
+	encoders := ParseEncoders(out)
+	log.Printf("Found %d ffmpeg encoders", len(encoders))
+	return encoders
+}
+
+// OutputCodecs describes an encoded rendition for the master playlist.
+type OutputCodecs struct {
+	Codecs    string // RFC 6381 codecs list, e.g. "avc1.64001f,mp4a.40.2"
+	FrameRate float64
+}
+
+// ProbeOutputCodecs runs ffprobe on an encoded segment or playlist.
+func ProbeOutputCodecs(ctx context.Context, path string) (*OutputCodecs, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	return ParseOutputCodecs(out)
+}
+
+// ParseOutputCodecs builds the CODECS attribute from `ffprobe -show_streams`
+// JSON output.
+func ParseOutputCodecs(data []byte) (*OutputCodecs, error) {
+	var probe ffprobeOutput
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("decode ffprobe output: %w", err)
+	}
+
+	result := new(OutputCodecs)
+	var video, audio string
+	for _, stream := range probe.Streams {
+		switch {
+		case stream.CodecType == "video" && video == "":
+			codec, err := videoCodecString(stream.CodecName, stream.Profile, stream.Level, stream.PixFmt)
+			if err != nil {
+				return nil, err
+			}
+			video = codec
+			result.FrameRate = parseRatio(stream.AvgFrameRate, "/")
+			if result.FrameRate == 0 {
+				result.FrameRate = parseRatio(stream.RFrameRate, "/")
+			}
+		case stream.CodecType == "audio" && audio == "":
+			codec, err := audioCodecString(stream.CodecName, stream.Profile)
+			if err != nil {
+				return nil, err
+			}
+			audio = codec
+		}
+	}
+
+	switch {
+	case video != "" && audio != "":
+		result.Codecs = video + "," + audio
+	case video != "":
+		result.Codecs = video
+	case audio != "":
+		result.Codecs = audio
+	default:
+		return nil, fmt.Errorf("no audio or video stream found")
+	}
+
+	return result, nil
+}
+
+// VideoPacket is one video packet reported by `ffprobe -show_packets`.
+type VideoPacket struct {
+	Time     float64 // presentation time in seconds
+	Pos      int64   // byte offset in the probed file
+	Size     int64
+	Keyframe bool
+}
+
+// ProbeVideoPackets lists the packets of the first video stream in file order.
+func ProbeVideoPackets(ctx context.Context, path string) ([]VideoPacket, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-print_format", "json",
+		"-show_entries", "packet=pts_time,pos,size,flags",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	return ParseVideoPackets(out)
+}
+
+// ParseVideoPackets reads `ffprobe -show_entries packet=...` JSON output.
+// Packets without a byte position are skipped.
+func ParseVideoPackets(data []byte) ([]VideoPacket, error) {
+	var probe struct {
+		Packets []struct {
+			PTSTime string `json:"pts_time"`
+			Pos     string `json:"pos"`
+			Size    string `json:"size"`
+			Flags   string `json:"flags"`
+		} `json:"packets"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("decode ffprobe output: %w", err)
+	}
+
+	packets := make([]VideoPacket, 0, len(probe.Packets))
+	for _, p := range probe.Packets {
+		pos, err := strconv.ParseInt(p.Pos, 10, 64)
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseInt(p.Size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet size %q", p.Size)
+		}
+		t, err := strconv.ParseFloat(p.PTSTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet time %q", p.PTSTime)
+		}
+
+		packets = append(packets, VideoPacket{
+			Time:     t,
+			Pos:      pos,
+			Size:     size,
+			Keyframe: strings.HasPrefix(p.Flags, "K"),
+		})
+	}
+
+	return packets, nil
+}
+
+// avcProfiles maps ffprobe H.264 profile names to profile_idc and the
+// constraint flags byte used in avc1 codec strings.
+var avcProfiles = map[string][2]byte{
+	"Constrained Baseline":  {0x42, 0xe0},
+	"Baseline":              {0x42, 0x00},
+	"Main":                  {0x4d, 0x40},
+	"Extended":              {0x58, 0x00},
+	"High":                  {0x64, 0x00},
+	"High 10":               {0x6e, 0x00},
+	"High 4:2:2":            {0x7a, 0x00},
+	"High 4:4:4 Predictive": {0xf4, 0x00},
+}
+
+// hevcProfiles maps ffprobe HEVC profile names to general_profile_idc and the
+// matching profile compatibility flags, as used in hvc1 codec strings.
+var hevcProfiles = map[string][2]int{
+	"Main":    {1, 6},
+	"Main 10": {2, 4},
+}
+
+// av1Profiles maps ffprobe AV1 profile names to seq_profile.
+var av1Profiles = map[string]int{
+	"Main":         0,
+	"High":         1,
+	"Professional": 2,
+}
+
+func videoCodecString(name, profile string, level int, pixFmt string) (string, error) {
+	switch name {
+	case "h264":
+		idc, ok := avcProfiles[profile]
+		if !ok {
+			return "", fmt.Errorf("unsupported h264 profile %q", profile)
+		}
+		return fmt.Sprintf("avc1.%02x%02x%02x", idc[0], idc[1], level), nil
+	case "hevc":
+		idc, ok := hevcProfiles[profile]
+		if !ok {
+			return "", fmt.Errorf("unsupported hevc profile %q", profile)
+		}
+		// Main tier with the progressive source constraint flags.
+		return fmt.Sprintf("hvc1.%d.%d.L%d.B0", idc[0], idc[1], level), nil
+	case "av1":
+		seqProfile, ok := av1Profiles[profile]
+		if !ok {
+			return "", fmt.Errorf("unsupported av1 profile %q", profile)
+		}
+		bitDepth := 8
+		if strings.Contains(pixFmt, "10") {
+			bitDepth = 10
+		} else if strings.Contains(pixFmt, "12") {
+			bitDepth = 12
+		}
+		return fmt.Sprintf("av01.%d.%02dM.%02d", seqProfile, level, bitDepth), nil
+	}
+
+	return "", fmt.Errorf("unsupported video codec %q", name)
+}
+
+func audioCodecString(name, profile string) (string, error) {
+	switch name {
+	case "aac":
+		switch profile {
+		case "HE-AAC":
+			return "mp4a.40.5", nil
+		case "HE-AACv2":
+			return "mp4a.40.29", nil
+		}
+		return "mp4a.40.2", nil
+	case "mp3":
+		return "mp4a.40.34", nil
+	case "ac3":
+		return "ac-3", nil
+	case "eac3":
+		return "ec-3", nil
+	}
+
+	return "", fmt.Errorf("unsupported audio codec %q", name)
+}
+
+func parseRatio(value, sep string) float64 {
+	num, den, ok := strings.Cut(value, sep)
+	if !ok {
+		return 0
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+
+	return n / d
+}
+
+// ReadFFmpegProgress parses the output of `ffmpeg -progress pipe:1` and calls fn
+// for every completed block. It returns when r is exhausted.
+func ReadFFmpegProgress(r io.Reader, fn func(FFmpegProgress)) error {
+	var current FFmpegProgress
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		// Despite the name, ffmpeg reports out_time_ms in microseconds,
+		// the same unit as out_time_us.
+		case "out_time_us", "out_time_ms":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				current.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "fps":
+			if fps, err := strconv.ParseFloat(value, 64); err == nil {
+				current.FPS = fps
+			}
+		case "speed":
+			if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+				current.Speed = speed
+			}
+		case "progress":
+			current.Done = value == "end"
+			fn(current)
+		}
+	}
+
+	return scanner.Err()
+}
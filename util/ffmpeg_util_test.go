@@ -0,0 +1,181 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFFmpegProgress(t *testing.T) {
+	output := `frame=120
+fps=29.97
+out_time_us=4000000
+out_time_ms=4000000
+out_time=00:00:04.000000
+speed=2.5x
+progress=continue
+frame=240
+fps=N/A
+out_time_ms=8000000
+speed=N/A
+progress=end
+`
+
+	var blocks []FFmpegProgress
+	if err := ReadFFmpegProgress(strings.NewReader(output), func(p FFmpegProgress) {
+		blocks = append(blocks, p)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 progress blocks, got %d", len(blocks))
+	}
+
+	first := blocks[0]
+	if first.OutTime != 4*time.Second || first.FPS != 29.97 || first.Speed != 2.5 || first.Done {
+		t.Fatalf("unexpected first block: %+v", first)
+	}
+
+	last := blocks[1]
+	if last.OutTime != 8*time.Second || !last.Done {
+		t.Fatalf("unexpected last block: %+v", last)
+	}
+	if last.FPS != 29.97 || last.Speed != 2.5 {
+		t.Fatalf("N/A values should keep the previous reading: %+v", last)
+	}
+}
+
+func TestParseProbeOutput(t *testing.T) {
+	output := `{
+  "streams": [
+    {"codec_type": "audio", "channels": 2, "tags": {"language": "eng", "title": "English"}},
+    {"codec_type": "audio", "channels": 6, "tags": {"language": "und"}, "disposition": {"default": 1}},
+    {
+      "codec_type": "video",
+      "width": 1920,
+      "height": 1080,
+      "sample_aspect_ratio": "1:1",
+      "r_frame_rate": "30/1",
+      "avg_frame_rate": "30000/1001",
+      "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]
+    }
+  ],
+  "format": {"duration": "12.500000"}
+}`
+
+	info, err := ParseProbeOutput([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Width != 1920 || info.Height != 1080 || info.Rotation != 90 || !info.HasAudio {
+		t.Fatalf("unexpected geometry: %+v", info)
+	}
+	if info.Duration != 12500*time.Millisecond {
+		t.Fatalf("unexpected duration: %v", info.Duration)
+	}
+	if info.FPS < 29.96 || info.FPS > 29.98 {
+		t.Fatalf("unexpected fps: %v", info.FPS)
+	}
+
+	want := []AudioStream{
+		{Index: 0, Language: "eng", Title: "English", Channels: 2},
+		{Index: 1, Channels: 6, Default: true},
+	}
+	if !reflect.DeepEqual(info.AudioStreams, want) {
+		t.Fatalf("unexpected audio streams: %+v", info.AudioStreams)
+	}
+
+	if w, h := info.DisplaySize(); w != 1080 || h != 1920 {
+		t.Fatalf("expected rotated display size 1080x1920, got %dx%d", w, h)
+	}
+
+	if _, err := ParseProbeOutput([]byte(`{"streams": [{"codec_type": "audio"}]}`)); err == nil {
+		t.Fatal("expected error for input without video")
+	}
+}
+
+func TestParseOutputCodecs(t *testing.T) {
+	output := `{"streams": [
+  {"codec_type": "video", "codec_name": "h264", "profile": "High", "level": 31, "avg_frame_rate": "25/1"},
+  {"codec_type": "audio", "codec_name": "aac", "profile": "LC"}
+]}`
+
+	codecs, err := ParseOutputCodecs([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codecs.Codecs != "avc1.64001f,mp4a.40.2" || codecs.FrameRate != 25 {
+		t.Fatalf("unexpected codecs: %+v", codecs)
+	}
+
+	audioOnly, err := ParseOutputCodecs([]byte(`{"streams": [{"codec_type": "audio", "codec_name": "aac", "profile": "LC"}]}`))
+	if err != nil || audioOnly.Codecs != "mp4a.40.2" {
+		t.Fatalf("unexpected audio-only codecs: %+v (%v)", audioOnly, err)
+	}
+
+	if _, err := ParseOutputCodecs([]byte(`{"streams": [{"codec_type": "video", "codec_name": "h264", "profile": "Weird"}]}`)); err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+}
+
+func TestParseOutputCodecsHEVCAndAV1(t *testing.T) {
+	tests := map[string]string{
+		`{"streams": [{"codec_type": "video", "codec_name": "hevc", "profile": "Main", "level": 120}]}`:                         "hvc1.1.6.L120.B0",
+		`{"streams": [{"codec_type": "video", "codec_name": "hevc", "profile": "Main 10", "level": 153}]}`:                      "hvc1.2.4.L153.B0",
+		`{"streams": [{"codec_type": "video", "codec_name": "av1", "profile": "Main", "level": 8, "pix_fmt": "yuv420p"}]}`:      "av01.0.08M.08",
+		`{"streams": [{"codec_type": "video", "codec_name": "av1", "profile": "Main", "level": 12, "pix_fmt": "yuv420p10le"}]}`: "av01.0.12M.10",
+	}
+
+	for output, want := range tests {
+		codecs, err := ParseOutputCodecs([]byte(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codecs.Codecs != want {
+			t.Errorf("got %q, want %q", codecs.Codecs, want)
+		}
+	}
+}
+
+func TestParseEncoders(t *testing.T) {
+	output := `Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D libsvtav1            SVT-AV1(Scalable Video Technology for AV1) encoder (codec av1)
+ A....D aac                  AAC (Advanced Audio Coding)
+`
+
+	encoders := ParseEncoders([]byte(output))
+	if !encoders["libx264"] || !encoders["libsvtav1"] || !encoders["aac"] {
+		t.Fatalf("missing encoders: %v", encoders)
+	}
+	if encoders["libx265"] || encoders["Video"] {
+		t.Fatalf("unexpected encoders: %v", encoders)
+	}
+}
+
+func TestParseVideoPackets(t *testing.T) {
+	output := `{"packets": [
+  {"pts_time": "1.400000", "pos": "564", "size": "9000", "flags": "K__"},
+  {"pts_time": "1.480000", "pos": "10904", "size": "1200", "flags": "___"},
+  {"pts_time": "N/A", "pos": "N/A", "size": "10", "flags": "___"}
+]}`
+
+	packets, err := ParseVideoPackets([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []VideoPacket{
+		{Time: 1.4, Pos: 564, Size: 9000, Keyframe: true},
+		{Time: 1.48, Pos: 10904, Size: 1200},
+	}
+	if !reflect.DeepEqual(packets, want) {
+		t.Fatalf("unexpected packets: %+v", packets)
+	}
+}
@@ -0,0 +1,162 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// EncryptFileAES128 encrypts a media segment in place with AES-128-CBC and
+// PKCS#7 padding, the scheme HLS clients expect for METHOD=AES-128.
+func EncryptFileAES128(path string, key, iv []byte) error {
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read segment: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("new cipher: %w", err)
+	}
+
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	return os.WriteFile(path, encrypted, 0644)
+}
+
+// MediaSegment is one #EXTINF entry of a media playlist.
+type MediaSegment struct {
+	URI      string
+	Duration float64
+}
+
+// ParseMediaPlaylist reads the segments of an HLS media playlist.
+func ParseMediaPlaylist(data []byte) ([]MediaSegment, error) {
+	var (
+		segments []MediaSegment
+		duration float64
+		pending  bool
+	)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			d, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EXTINF %q: %w", line, err)
+			}
+			duration, pending = d, true
+		case line == "" || strings.HasPrefix(line, "#"):
+		case pending:
+			segments = append(segments, MediaSegment{URI: line, Duration: duration})
+			pending = false
+		}
+	}
+
+	return segments, nil
+}
+
+// PlaylistBandwidth returns the peak and average bitrate in bits per second of
+// the segments listed in a media playlist stored in dir.
+func PlaylistBandwidth(dir string, segments []MediaSegment) (int, int, error) {
+	var (
+		peak          float64
+		totalBits     float64
+		totalDuration float64
+	)
+
+	for _, segment := range segments {
+		stat, err := os.Stat(filepath.Join(dir, segment.URI))
+		if err != nil {
+			return 0, 0, fmt.Errorf("stat segment: %w", err)
+		}
+		if segment.Duration <= 0 {
+			continue
+		}
+
+		bits := float64(stat.Size() * 8)
+		peak = max(peak, bits/segment.Duration)
+		totalBits += bits
+		totalDuration += segment.Duration
+	}
+
+	if totalDuration == 0 {
+		return 0, 0, fmt.Errorf("playlist has no timed segments")
+	}
+
+	return int(math.Ceil(peak)), int(math.Ceil(totalBits / totalDuration)), nil
+}
+
+const tsPacketSize = 188
+
+// TSHeaderLength returns the length of the program tables (SDT, PAT and the
+// PMTs it lists) an MPEG-TS segment starts with, which is the section a
+// decoder needs before it can read packets from the middle of the segment.
+func TSHeaderLength(data []byte) int64 {
+	tables := map[int]bool{0x0000: true, 0x0011: true}
+
+	var offset int
+	for ; offset+tsPacketSize <= len(data); offset += tsPacketSize {
+		packet := data[offset : offset+tsPacketSize]
+		if packet[0] != 0x47 {
+			break
+		}
+		pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+		if !tables[pid] {
+			break
+		}
+		if pid == 0 {
+			for _, pmt := range patProgramMapPIDs(packet) {
+				tables[pmt] = true
+			}
+		}
+	}
+
+	return int64(offset)
+}
+
+// patProgramMapPIDs reads the PMT PIDs of a PAT carried in a single packet.
+func patProgramMapPIDs(packet []byte) []int {
+	payload := packet[4:]
+	if packet[3]&0x20 != 0 { // adaptation field
+		if len(payload) == 0 || int(payload[0])+1 > len(payload) {
+			return nil
+		}
+		payload = payload[int(payload[0])+1:]
+	}
+	if packet[1]&0x40 != 0 { // pointer field
+		if len(payload) == 0 || int(payload[0])+1 > len(payload) {
+			return nil
+		}
+		payload = payload[int(payload[0])+1:]
+	}
+	if len(payload) < 8 || payload[0] != 0x00 {
+		return nil
+	}
+
+	// The section length counts from after its own field and includes the
+	// trailing CRC; programs follow the 5 byte header.
+	sectionLength := int(payload[1]&0x0f)<<8 | int(payload[2])
+	end := min(3+sectionLength-4, len(payload))
+
+	var pids []int
+	for i := 8; i+4 <= end; i += 4 {
+		program := int(payload[i])<<8 | int(payload[i+1])
+		if program != 0 { // program 0 points at the network PID
+			pids = append(pids, int(payload[i+2]&0x1f)<<8|int(payload[i+3]))
+		}
+	}
+	return pids
+}
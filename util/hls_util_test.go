@@ -0,0 +1,103 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptFileAES128(t *testing.T) {
+	key := bytes.Repeat([]byte{0x11}, 16)
+	iv := bytes.Repeat([]byte{0x22}, 16)
+	plain := bytes.Repeat([]byte("segment"), 100) // 700 bytes, not block aligned
+
+	path := filepath.Join(t.TempDir(), "360p_000.ts")
+	if err := os.WriteFile(path, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EncryptFileAES128(path, key, iv); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted)%aes.BlockSize != 0 || len(encrypted) <= len(plain) {
+		t.Fatalf("unexpected ciphertext length %d", len(encrypted))
+	}
+
+	block, _ := aes.NewCipher(key)
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+
+	padding := int(decrypted[len(decrypted)-1])
+	if !bytes.Equal(decrypted[:len(decrypted)-padding], plain) {
+		t.Fatal("decrypted segment does not match the original")
+	}
+}
+
+func TestPlaylistBandwidth(t *testing.T) {
+	dir := t.TempDir()
+	playlist := []byte("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.000000,\na.ts\n#EXTINF:2.000000,\nb.ts\n#EXT-X-ENDLIST\n")
+
+	segments, err := ParseMediaPlaylist(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || segments[1].URI != "b.ts" || segments[1].Duration != 2 {
+		t.Fatalf("unexpected segments: %+v", segments)
+	}
+
+	// 4s at 1000 bytes = 2000 bps, 2s at 1000 bytes = 4000 bps.
+	for _, name := range []string{"a.ts", "b.ts"} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	peak, average, err := PlaylistBandwidth(dir, segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak != 4000 || average != 2667 {
+		t.Fatalf("got peak %d average %d, want 4000 and 2667", peak, average)
+	}
+}
+
+func tsPacket(pid int, payload []byte) []byte {
+	packet := bytes.Repeat([]byte{0xff}, 188)
+	packet[0] = 0x47
+	packet[1] = 0x40 | byte(pid>>8) // payload unit start
+	packet[2] = byte(pid)
+	packet[3] = 0x10 // payload only
+	copy(packet[4:], payload)
+	return packet
+}
+
+func TestTSHeaderLength(t *testing.T) {
+	// Pointer field, then a PAT listing program 1 on PMT PID 0x1000.
+	pat := []byte{0x00, 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00, 0, 0, 0, 0}
+	sdt := tsPacket(0x11, []byte{0x00, 0x42})
+	pmt := tsPacket(0x1000, []byte{0x00, 0x02})
+	video := tsPacket(0x100, []byte{0x00, 0x00, 0x01, 0xe0})
+
+	segment := bytes.Join([][]byte{sdt, tsPacket(0, pat), pmt, video, pmt}, nil)
+	if got := TSHeaderLength(segment); got != 3*188 {
+		t.Fatalf("got %d, want %d", got, 3*188)
+	}
+
+	// A PMT PID the PAT does not list is media.
+	segment = bytes.Join([][]byte{tsPacket(0, pat), tsPacket(0x1001, nil), video}, nil)
+	if got := TSHeaderLength(segment); got != 188 {
+		t.Fatalf("got %d, want 188", got)
+	}
+
+	if got := TSHeaderLength([]byte("not a transport stream")); got != 0 {
+		t.Fatalf("got %d, want 0", got)
+	}
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultImportMaxSize = 20 << 30 // 20 GiB
+	defaultImportTimeout = time.Hour
+)
+
+var ErrImportAddressBlocked = errors.New("address is not a public internet address")
+
+// ImportConfig configures importing sources from remote URLs.
+type ImportConfig struct {
+	MaxSize int64         // largest accepted source
+	Timeout time.Duration // limit for the whole download
+	// AllowPrivateNetworks lets imports reach loopback and private
+	// addresses, which are refused by default so the endpoint cannot be used
+	// to probe the internal network.
+	AllowPrivateNetworks bool
+}
+
+// InitImportConfig reads IMPORT_MAX_SIZE (bytes), IMPORT_TIMEOUT (e.g. "1h")
+// and IMPORT_ALLOW_PRIVATE_NETWORKS.
+func InitImportConfig() ImportConfig {
+	config := ImportConfig{
+		MaxSize: defaultImportMaxSize,
+		Timeout: defaultImportTimeout,
+	}
+
+	if value := os.Getenv("IMPORT_MAX_SIZE"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			log.Fatalf("Invalid IMPORT_MAX_SIZE %q", value)
+		}
+		config.MaxSize = size
+	}
+
+	if value := os.Getenv("IMPORT_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			log.Fatalf("Invalid IMPORT_TIMEOUT %q", value)
+		}
+		config.Timeout = timeout
+	}
+
+	if value := os.Getenv("IMPORT_ALLOW_PRIVATE_NETWORKS"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("Invalid IMPORT_ALLOW_PRIVATE_NETWORKS %q", value)
+		}
+		config.AllowPrivateNetworks = allow
+	}
+
+	return config
+}
+
+// NewImportClient returns the HTTP client imports download with. Unless
+// private networks are allowed, every connection, including those of
+// redirects, is checked after DNS resolution. Proxies are not used so the
+// check sees the real destination.
+func NewImportClient(config ImportConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !config.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return ErrImportAddressBlocked
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// IsPublicIP reports whether ip is a globally routable unicast address.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	// Carrier-grade NAT, 100.64.0.0/10.
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var ErrUnknownMasterKey = errors.New("content key wrapped with an unknown master key")
+
+// MasterKeyring wraps content keys with AES-256-GCM. New keys are always
+// wrapped with the current master key; previous master keys are only kept to
+// unwrap keys until they have been rewrapped, which is how the master key is
+// rotated without touching the encoded media.
+type MasterKeyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewMasterKeyring builds a keyring from 32 byte master keys.
+func NewMasterKeyring(current []byte, previous ...[]byte) (*MasterKeyring, error) {
+	ring := &MasterKeyring{keys: make(map[string]cipher.AEAD)}
+
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %d must be 32 bytes, got %d", i, len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := MasterKeyID(key)
+		if i == 0 {
+			ring.current = id
+		}
+		ring.keys[id] = aead
+	}
+
+	return ring, nil
+}
+
+// InitMasterKeyring loads the current master key from KEYSTORE_MASTER_KEY
+// (base64) or from the file named by KEYSTORE_MASTER_KEY_FILE, and retired
+// master keys from KEYSTORE_PREVIOUS_MASTER_KEYS (comma separated base64).
+func InitMasterKeyring() *MasterKeyring {
+	encoded := os.Getenv("KEYSTORE_MASTER_KEY")
+	if path := os.Getenv("KEYSTORE_MASTER_KEY_FILE"); encoded == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read master key file: %v", err)
+		}
+		encoded = string(data)
+	}
+	if strings.TrimSpace(encoded) == "" {
+		log.Fatal("KEYSTORE_MASTER_KEY or KEYSTORE_MASTER_KEY_FILE is required for the envelope key store")
+	}
+
+	current, err := decodeMasterKey(encoded)
+	if err != nil {
+		log.Fatalf("Invalid master key: %v", err)
+	}
+
+	var previous [][]byte
+	for _, value := range strings.Split(os.Getenv("KEYSTORE_PREVIOUS_MASTER_KEYS"), ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		key, err := decodeMasterKey(value)
+		if err != nil {
+			log.Fatalf("Invalid previous master key: %v", err)
+		}
+		previous = append(previous, key)
+	}
+
+	ring, err := NewMasterKeyring(current, previous...)
+	if err != nil {
+		log.Fatalf("Failed to load master keys: %v", err)
+	}
+
+	log.Printf("Loaded master key %s (%d previous)", ring.CurrentID(), len(previous))
+	return ring
+}
+
+func decodeMasterKey(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+}
+
+// MasterKeyID identifies a master key without revealing it.
+func MasterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (r *MasterKeyring) CurrentID() string {
+	return r.current
+}
+
+// Wrap encrypts a content key with the current master key. aad binds the
+// wrapped key to where it is stored so it cannot be swapped for another.
+func (r *MasterKeyring) Wrap(plain, aad []byte) (string, []byte, []byte, error) {
+	aead := r.keys[r.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	return r.current, nonce, aead.Seal(nil, nonce, plain, aad), nil
+}
+
+// Unwrap decrypts a content key wrapped with any master key of the ring.
+func (r *MasterKeyring) Unwrap(keyID string, nonce, ciphertext, aad []byte) ([]byte, error) {
+	aead, ok := r.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownMasterKey
+	}
+
+	plain, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap content key: %w", err)
+	}
+
+	return plain, nil
+}
@@ -0,0 +1,269 @@
+package util
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrPresignInvalid = errors.New("presigned url invalid or expired")
+
+// LocalStoreRoute is the route of this API that serves presigned local
+// objects.
+const LocalStoreRoute = "/storage"
+
+// partialSuffix marks files still being written by PutObject.
+const partialSuffix = ".partial"
+
+// LocalStore keeps objects on the local disk. Presigned URLs point at
+// LocalStoreRoute and carry an HMAC over the method, key and expiry, like S3
+// query string signatures, so the route can serve and accept objects without
+// other auth.
+type LocalStore struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+func NewLocalStore(root, baseURL string, secret []byte) *LocalStore {
+	if root == "" {
+		root = filepath.Join("data", "storage")
+	}
+	return &LocalStore{root: root, baseURL: strings.TrimRight(baseURL, "/"), secret: secret}
+}
+
+// InitLocalStore reads the storage directory from STORAGE_DIR, the public
+// address of this API from STORAGE_PUBLIC_URL and the signing secret from
+// STORAGE_SIGNING_SECRET. Without a secret a random one is used, so presigned
+// URLs stop working when the process restarts.
+func InitLocalStore() *LocalStore {
+	baseURL := os.Getenv("STORAGE_PUBLIC_URL")
+	if baseURL == "" {
+		baseURL = os.Getenv("HTTP_PROTOCOL") + os.Getenv("BASE_IP_URL") + ":" + os.Getenv("PORT")
+	}
+
+	secret := []byte(os.Getenv("STORAGE_SIGNING_SECRET"))
+	if len(secret) == 0 {
+		log.Print("STORAGE_SIGNING_SECRET is not set, using a random secret")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed to generate storage secret: %v", err)
+		}
+	}
+
+	store := NewLocalStore(os.Getenv("STORAGE_DIR"), baseURL, secret)
+	if err := os.MkdirAll(store.root, 0755); err != nil {
+		log.Fatalf("Failed to create storage directory: %v", err)
+	}
+
+	log.Printf("Using local storage: %s", store.root)
+	return store
+}
+
+// Path returns the file backing key.
+func (s *LocalStore) Path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "\\") || path.IsAbs(key) || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") || strings.HasSuffix(key, partialSuffix) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// PutObject writes to a temporary file first so readers never see a
+// partially written object. Options are not stored: the content type and
+// cache headers are derived from the extension when the object is served.
+func (s *LocalStore) PutObject(ctx context.Context, key string, reader io.Reader, size int64, opts PutOptions) error {
+	target, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*"+partialSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("got %d of %d bytes", written, size)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	if err := os.Rename(file.Name(), target); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *LocalStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	target, err := s.Path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStore) StatObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	target, err := s.Path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  ObjectContentType(key),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+// RemoveObject succeeds when the object does not exist, as S3 does.
+func (s *LocalStore) RemoveObject(ctx context.Context, key string) error {
+	target, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ListObjects returns every object whose key starts with prefix, sorted by
+// key.
+func (s *LocalStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(s.root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(name, partialSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			ContentType:  ObjectContentType(key),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *LocalStore) PresignedGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return s.presign(http.MethodGet, key, "", time.Now().Add(expires))
+}
+
+func (s *LocalStore) PresignedPutObject(ctx context.Context, key string, size int64, expires time.Duration) (string, error) {
+	return s.presign(http.MethodPut, key, strconv.FormatInt(size, 10), time.Now().Add(expires))
+}
+
+// presign signs method together with the key, so a download URL cannot be
+// used to overwrite the object. Upload URLs also sign the size of the body
+// they accept.
+func (s *LocalStore) presign(method, key, size string, expiresAt time.Time) (string, error) {
+	if _, err := s.Path(key); err != nil {
+		return "", err
+	}
+
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{
+		"expires":   {expiry},
+		"signature": {s.sign(method, key, size, expiry)},
+	}
+	if size != "" {
+		query.Set("size", size)
+	}
+	return fmt.Sprintf("%s%s/%s?%s", s.baseURL, LocalStoreRoute, (&url.URL{Path: key}).EscapedPath(), query.Encode()), nil
+}
+
+// VerifyPresigned checks the query parameters of a presigned URL for method
+// and key. size is empty for downloads.
+func (s *LocalStore) VerifyPresigned(method, key, size, expires, signature string, now time.Time) error {
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() >= expiry {
+		return ErrPresignInvalid
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrPresignInvalid
+	}
+	want, _ := hex.DecodeString(s.sign(method, key, size, expires))
+	if !hmac.Equal(got, want) {
+		return ErrPresignInvalid
+	}
+
+	return nil
+}
+
+func (s *LocalStore) sign(method, key, size, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(method + "\n" + key + "\n" + expires))
+	if size != "" {
+		mac.Write([]byte("\n" + size))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
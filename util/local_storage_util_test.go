@@ -0,0 +1,148 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreObjects(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "http://api:5000", []byte("secret"))
+	ctx := context.Background()
+
+	for _, key := range []string{"videos/a/master.m3u8", "videos/a/360p_000.ts", "videos/b/master.m3u8"} {
+		if err := store.PutObject(ctx, key, strings.NewReader(key), -1, PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	obj, err := store.GetObject(ctx, "videos/a/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(obj)
+	obj.Close()
+	if string(data) != "videos/a/master.m3u8" {
+		t.Fatalf("unexpected object %q", data)
+	}
+
+	info, err := store.StatObject(ctx, "videos/a/360p_000.ts")
+	if err != nil || info.Size != int64(len("videos/a/360p_000.ts")) || info.ContentType != "video/mp2t" {
+		t.Fatalf("unexpected stat %+v (%v)", info, err)
+	}
+
+	objects, err := store.ListObjects(ctx, "videos/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	if want := []string{"videos/a/360p_000.ts", "videos/a/master.m3u8"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+
+	if err := store.RemoveObject(ctx, "videos/a/master.m3u8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveObject(ctx, "videos/a/master.m3u8"); err != nil {
+		t.Fatalf("removing a missing object should succeed: %v", err)
+	}
+	if _, err := store.GetObject(ctx, "videos/a/master.m3u8"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound, got %v", err)
+	}
+	if _, err := store.StatObject(ctx, "videos/a"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected a directory to be reported missing, got %v", err)
+	}
+
+	for _, key := range []string{"", "/etc/passwd", "../secret", "videos/../../secret", "videos\\a"} {
+		if err := store.PutObject(ctx, key, strings.NewReader("x"), 1, PutOptions{}); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+}
+
+func TestLocalStorePresign(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "http://api:5000/", []byte("secret"))
+	now := time.Unix(1700000000, 0)
+
+	raw, err := store.presign(http.MethodGet, "videos/my video/360p_000.ts", "", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, "http://api:5000/storage/videos/my%20video/360p_000.ts?") {
+		t.Fatalf("unexpected url %s", raw)
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.TrimPrefix(parsed.Path, LocalStoreRoute+"/")
+	expires, signature := parsed.Query().Get("expires"), parsed.Query().Get("signature")
+
+	if err := store.VerifyPresigned(http.MethodGet, key, "", expires, signature, now); err != nil {
+		t.Fatalf("valid url rejected: %v", err)
+	}
+	if err := store.VerifyPresigned(http.MethodGet, key, "", expires, signature, now.Add(2*time.Hour)); !errors.Is(err, ErrPresignInvalid) {
+		t.Errorf("expired url accepted: %v", err)
+	}
+	if err := store.VerifyPresigned(http.MethodGet, "videos/other/360p_000.ts", "", expires, signature, now); !errors.Is(err, ErrPresignInvalid) {
+		t.Errorf("url accepted for another key: %v", err)
+	}
+	if err := store.VerifyPresigned(http.MethodGet, key, "", "1800000000", signature, now); !errors.Is(err, ErrPresignInvalid) {
+		t.Errorf("extended expiry accepted: %v", err)
+	}
+	if err := store.VerifyPresigned(http.MethodPut, key, "", expires, signature, now); !errors.Is(err, ErrPresignInvalid) {
+		t.Errorf("download url accepted for an upload: %v", err)
+	}
+
+	raw, err = store.presign(http.MethodPut, "incoming/v1", "500", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("size") != "500" {
+		t.Fatalf("upload url %s does not carry its size", raw)
+	}
+	if err := store.VerifyPresigned(http.MethodPut, "incoming/v1", "500", query.Get("expires"), query.Get("signature"), now); err != nil {
+		t.Fatalf("valid upload url rejected: %v", err)
+	}
+	if err := store.VerifyPresigned(http.MethodPut, "incoming/v1", "5000", query.Get("expires"), query.Get("signature"), now); !errors.Is(err, ErrPresignInvalid) {
+		t.Errorf("upload url accepted for another size: %v", err)
+	}
+}
+
+func TestObjectPutOptions(t *testing.T) {
+	tests := []struct {
+		key          string
+		contentType  string
+		cacheControl string
+	}{
+		{"v/master.m3u8", "application/vnd.apple.mpegurl", playlistCacheControl},
+		{"v/360p_000.ts", "video/mp2t", immutableCacheControl},
+		{"v/720p_000.m4s", "video/iso.segment", immutableCacheControl},
+		{"v/720p_init.mp4", "video/mp4", immutableCacheControl},
+		{"v/subs_en_000.vtt", "text/vtt", playlistCacheControl},
+		{"v/thumbs_000.jpg", "image/jpeg", immutableCacheControl},
+		{"v/manifest.mpd", "application/dash+xml", playlistCacheControl},
+	}
+
+	for _, tt := range tests {
+		opts := ObjectPutOptions(tt.key)
+		if opts.ContentType != tt.contentType || opts.CacheControl != tt.cacheControl {
+			t.Errorf("%s: got %+v", tt.key, opts)
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package util
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"net/url"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/minio/minio-go/v7"
@@ -62,14 +63,21 @@ func InitMinio() *Minio {
 	}
 }
 
-// UploadToS3 uploads file to MinIO bucket
-func (u *Minio) UploadToS3(ctx context.Context, bucket, objectName string, data []byte) error {
-	reader := bytes.NewReader(data)
-	_, err := u.minioClient.PutObject(ctx, bucket, objectName, reader, int64(len(data)), minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
+// PutObject streams an object of the given size, or -1 if unknown, to the
+// bucket. Large objects are sent as a multipart upload; when reader is a file
+// minio-go uploads the parts in parallel straight from it.
+func (u *Minio) PutObject(ctx context.Context, key string, reader io.Reader, size int64, opts PutOptions) error {
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = ObjectContentType(key)
+	}
+
+	_, err := u.minioClient.PutObject(ctx, u.buckeName, key, reader, size, minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: opts.CacheControl,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to upload %s: %w", objectName, err)
+		return fmt.Errorf("failed to upload %s: %w", key, err)
 	}
 	return nil
 }
@@ -78,20 +86,81 @@ func (u *Minio) GetBucketName() string {
 	return u.buckeName
 }
 
-func (u *Minio) GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error) {
-	object, err := u.minioClient.GetObject(ctx, bucketName, objectName, opts)
+// GetObject opens an object. minio-go only sends the request on first use,
+// so the object is stat'ed here to report a missing key up front.
+func (u *Minio) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := u.minioClient.GetObject(ctx, u.buckeName, key, minio.GetObjectOptions{})
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, minioError(err)
+	}
+
 	return object, nil
 }
 
-func (u *Minio) PresignedGetObject(ctx context.Context, bucketName, objectName string, expires time.Duration, reqParams url.Values) (*url.URL, error) {
-	object, err := u.minioClient.PresignedGetObject(ctx, bucketName, objectName, expires, reqParams)
+func (u *Minio) StatObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := u.minioClient.StatObject(ctx, u.buckeName, key, minio.StatObjectOptions{})
 	if err != nil {
-		return nil, err
+		return nil, minioError(err)
 	}
 
-	return object, nil
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (u *Minio) RemoveObject(ctx context.Context, key string) error {
+	return u.minioClient.RemoveObject(ctx, u.buckeName, key, minio.RemoveObjectOptions{})
+}
+
+func (u *Minio) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for info := range u.minioClient.ListObjects(ctx, u.buckeName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          info.Key,
+			Size:         info.Size,
+			ContentType:  info.ContentType,
+			LastModified: info.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+func (u *Minio) PresignedGetObject(ctx context.Context, key string, expires time.Duration) (string, error) {
+	object, err := u.minioClient.PresignedGetObject(ctx, u.buckeName, key, expires, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return object.String(), nil
+}
+
+// PresignedPutObject signs the Content-Length header, so the storage rejects
+// a body of any other size.
+func (u *Minio) PresignedPutObject(ctx context.Context, key string, size int64, expires time.Duration) (string, error) {
+	header := http.Header{"Content-Length": {strconv.FormatInt(size, 10)}}
+	object, err := u.minioClient.PresignHeader(ctx, http.MethodPut, u.buckeName, key, expires, nil, header)
+	if err != nil {
+		return "", err
+	}
+
+	return object.String(), nil
+}
+
+func minioError(err error) error {
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NotFound" {
+		return ErrObjectNotFound
+	}
+	return err
 }
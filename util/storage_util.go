@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"time"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// PutOptions are the headers stored with an object.
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+}
+
+// ObjectStore is where encoded videos are kept. Keys are slash separated
+// paths relative to the store, e.g. "<video dir>/master.m3u8".
+type ObjectStore interface {
+	PutObject(ctx context.Context, key string, reader io.Reader, size int64, opts PutOptions) error
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	StatObject(ctx context.Context, key string) (*ObjectInfo, error)
+	RemoveObject(ctx context.Context, key string) error
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PresignedGetObject(ctx context.Context, key string, expires time.Duration) (string, error)
+	// PresignedPutObject returns a URL a client can PUT the object body to.
+	// The URL only accepts a body of exactly size bytes, sent with its
+	// Content-Length.
+	PresignedPutObject(ctx context.Context, key string, size int64, expires time.Duration) (string, error)
+}
+
+// InitObjectStore selects the storage backend from STORAGE_BACKEND: "minio"
+// (default) or "local", which keeps objects under STORAGE_DIR and serves them
+// through the signed /storage route of this API.
+func InitObjectStore() ObjectStore {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "minio":
+		return InitMinio()
+	case "local":
+		return InitLocalStore()
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q", backend)
+		return nil
+	}
+}
+
+// Segments, init segments and thumbnail sheets never change once written, so
+// CDNs and players may keep them for good. Playlists, manifests and WebVTT
+// files are rewritten when subtitles are added and are only cached briefly.
+const (
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	playlistCacheControl  = "public, max-age=10"
+)
+
+// ObjectPutOptions returns the headers an object is uploaded with.
+func ObjectPutOptions(key string) PutOptions {
+	return PutOptions{
+		ContentType:  ObjectContentType(key),
+		CacheControl: ObjectCacheControl(key),
+	}
+}
+
+// ObjectCacheControl returns the Cache-Control header served with key.
+func ObjectCacheControl(key string) string {
+	switch path.Ext(key) {
+	case ".ts", ".m4s", ".mp4", ".jpg", ".jpeg", ".png":
+		return immutableCacheControl
+	}
+	return playlistCacheControl
+}
+
+// ObjectContentType returns the content type a player expects for key.
+func ObjectContentType(key string) string {
+	switch path.Ext(key) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "video/mp4"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".vtt":
+		return "text/vtt"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
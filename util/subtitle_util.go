@@ -0,0 +1,213 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubtitleCue is one timed caption of an SRT or WebVTT file.
+type SubtitleCue struct {
+	Start    time.Duration
+	End      time.Duration
+	Settings string // WebVTT cue settings, e.g. "line:90% align:center"
+	Text     string
+}
+
+// SubtitleSegment is one WebVTT file of an HLS subtitles playlist.
+type SubtitleSegment struct {
+	URI      string
+	Duration float64
+	Data     []byte
+}
+
+// mpegtsStartPTS is where ffmpeg starts the timestamps of MPEG-TS output by
+// default: 1.4 s at 90 kHz. fMP4 segments start at 0.
+const mpegtsStartPTS = 126000
+
+var subtitleTiming = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}[.,]\d{3})\s+-->\s+((?:\d+:)?\d{2}:\d{2}[.,]\d{3})(.*)$`)
+
+// ParseSubtitles reads the cues of an SRT or WebVTT file. Files starting with
+// the WEBVTT signature are read as WebVTT, anything else as SRT.
+func ParseSubtitles(data []byte) ([]SubtitleCue, error) {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	webVTT := strings.HasPrefix(text, "WEBVTT")
+
+	var cues []SubtitleCue
+	for i, block := range strings.Split(text, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if block == "" {
+			continue
+		}
+		if webVTT && (i == 0 || strings.HasPrefix(block, "NOTE") || strings.HasPrefix(block, "STYLE") || strings.HasPrefix(block, "REGION")) {
+			continue
+		}
+
+		lines := strings.Split(block, "\n")
+		timing := 0
+		if !strings.Contains(lines[0], "-->") {
+			timing = 1 // SRT counter or WebVTT cue identifier
+		}
+		if timing >= len(lines) {
+			return nil, fmt.Errorf("cue %q has no timing line", lines[0])
+		}
+
+		match := subtitleTiming.FindStringSubmatch(strings.TrimSpace(lines[timing]))
+		if match == nil {
+			return nil, fmt.Errorf("invalid cue timing %q", lines[timing])
+		}
+
+		start, err := parseSubtitleTime(match[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseSubtitleTime(match[2])
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, fmt.Errorf("cue %q ends before it starts", lines[timing])
+		}
+
+		cue := SubtitleCue{
+			Start: start,
+			End:   end,
+			Text:  strings.Join(lines[timing+1:], "\n"),
+		}
+		if webVTT {
+			cue.Settings = strings.TrimSpace(match[3])
+		}
+		cues = append(cues, cue)
+	}
+
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no subtitle cues found")
+	}
+
+	return cues, nil
+}
+
+// parseSubtitleTime parses "HH:MM:SS,mmm" (SRT) or "[HH:]MM:SS.mmm" (WebVTT).
+func parseSubtitleTime(value string) (time.Duration, error) {
+	value = strings.Replace(value, ",", ".", 1)
+	parts := strings.Split(value, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"0"}, parts...)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || seconds >= 60 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(math.Round(seconds*1000))*time.Millisecond, nil
+}
+
+// FormatWebVTT writes cues as a standalone WebVTT document.
+func FormatWebVTT(cues []SubtitleCue) []byte {
+	var builder strings.Builder
+	builder.WriteString("WEBVTT\n")
+	writeCues(&builder, cues)
+	return []byte(builder.String())
+}
+
+// SegmentWebVTT splits cues along the given media segments so the subtitles
+// playlist lines up with the video playlists. Cues crossing a boundary are
+// repeated in both segments, which players merge back together.
+func SegmentWebVTT(cues []SubtitleCue, segments []MediaSegment, name string) []SubtitleSegment {
+	out := make([]SubtitleSegment, 0, len(segments))
+	timestampMap := subtitleTimestampMap(segments)
+
+	var start float64
+	for i, segment := range segments {
+		end := start + segment.Duration
+		from := time.Duration(math.Round(start * float64(time.Second)))
+		to := time.Duration(math.Round(end * float64(time.Second)))
+
+		var inSegment []SubtitleCue
+		for _, cue := range cues {
+			if cue.Start < to && cue.End > from {
+				inSegment = append(inSegment, cue)
+			}
+		}
+
+		var builder strings.Builder
+		builder.WriteString("WEBVTT\n")
+		builder.WriteString(timestampMap + "\n")
+		writeCues(&builder, inSegment)
+
+		out = append(out, SubtitleSegment{
+			URI:      fmt.Sprintf("%s_%03d.vtt", name, i),
+			Duration: segment.Duration,
+			Data:     []byte(builder.String()),
+		})
+		start = end
+	}
+
+	return out
+}
+
+// subtitleTimestampMap anchors the cue times at the first media timestamp of
+// the segments, which is where every subtitles segment is measured from.
+func subtitleTimestampMap(segments []MediaSegment) string {
+	var start int64
+	if len(segments) > 0 {
+		uri, _, _ := strings.Cut(segments[0].URI, "?")
+		if strings.EqualFold(path.Ext(uri), ".ts") {
+			start = mpegtsStartPTS
+		}
+	}
+	return fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000", start)
+}
+
+// SubtitlePlaylist builds the HLS media playlist listing the segments.
+func SubtitlePlaylist(segments []SubtitleSegment) []byte {
+	target := 1.0
+	for _, segment := range segments {
+		target = math.Max(target, segment.Duration)
+	}
+
+	var builder strings.Builder
+	builder.WriteString("#EXTM3U\n")
+	builder.WriteString("#EXT-X-VERSION:3\n")
+	builder.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target))))
+	builder.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	builder.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for _, segment := range segments {
+		builder.WriteString(fmt.Sprintf("#EXTINF:%.6f,\n%s\n", segment.Duration, segment.URI))
+	}
+	builder.WriteString("#EXT-X-ENDLIST\n")
+
+	return []byte(builder.String())
+}
+
+func writeCues(builder *strings.Builder, cues []SubtitleCue) {
+	for _, cue := range cues {
+		builder.WriteString(fmt.Sprintf("\n%s --> %s", FormatVTTTime(cue.Start), FormatVTTTime(cue.End)))
+		if cue.Settings != "" {
+			builder.WriteString(" " + cue.Settings)
+		}
+		builder.WriteString("\n" + cue.Text + "\n")
+	}
+}
+
+// FormatVTTTime formats d as a WebVTT timestamp, e.g. 00:01:02.500.
+func FormatVTTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSubtitlesSRT(t *testing.T) {
+	srt := "\ufeff1\r\n00:00:01,000 --> 00:00:03,500\r\nHello\r\nworld\r\n\r\n2\r\n00:00:05,250 --> 00:00:07,000\r\nSecond cue\r\n"
+
+	cues, err := ParseSubtitles([]byte(srt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 2 {
+		t.Fatalf("expected 2 cues, got %d", len(cues))
+	}
+	if cues[0].Start != time.Second || cues[0].End != 3500*time.Millisecond || cues[0].Text != "Hello\nworld" {
+		t.Fatalf("unexpected first cue: %+v", cues[0])
+	}
+
+	want := "WEBVTT\n\n00:00:01.000 --> 00:00:03.500\nHello\nworld\n\n00:00:05.250 --> 00:00:07.000\nSecond cue\n"
+	if got := string(FormatWebVTT(cues)); got != want {
+		t.Fatalf("unexpected WebVTT:\n%s", got)
+	}
+}
+
+func TestParseSubtitlesWebVTT(t *testing.T) {
+	vtt := "WEBVTT - course captions\n\nNOTE written by hand\n\nintro\n00:01.000 --> 00:02.000 align:start line:90%\nShort timestamps\n\n01:00:00.000 --> 01:00:01.000\nOne hour in\n"
+
+	cues, err := ParseSubtitles([]byte(vtt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 2 {
+		t.Fatalf("expected 2 cues, got %+v", cues)
+	}
+	if cues[0].Settings != "align:start line:90%" || cues[0].Start != time.Second {
+		t.Fatalf("unexpected first cue: %+v", cues[0])
+	}
+	if cues[1].Start != time.Hour {
+		t.Fatalf("unexpected second cue: %+v", cues[1])
+	}
+
+	for _, invalid := range []string{"", "WEBVTT\n", "1\n00:00:05,000 --> 00:00:01,000\nbackwards\n", "1\nnot a timing line\n"} {
+		if _, err := ParseSubtitles([]byte(invalid)); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestSegmentWebVTT(t *testing.T) {
+	cues := []SubtitleCue{
+		{Start: time.Second, End: 2 * time.Second, Text: "first"},
+		{Start: 3 * time.Second, End: 5 * time.Second, Text: "crosses"},
+		{Start: 9 * time.Second, End: 10 * time.Second, Text: "last"},
+	}
+	segments := []MediaSegment{{URI: "360p_000.ts", Duration: 4}, {URI: "360p_001.ts", Duration: 4}, {URI: "360p_002.ts", Duration: 2.5}}
+
+	out := SegmentWebVTT(cues, segments, "subs_en")
+	if len(out) != 3 || out[1].URI != "subs_en_001.vtt" || out[2].Duration != 2.5 {
+		t.Fatalf("unexpected segments: %+v", out)
+	}
+
+	first, second := string(out[0].Data), string(out[1].Data)
+	if !strings.HasPrefix(first, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000\n") || !strings.Contains(first, "first") || !strings.Contains(first, "crosses") {
+		t.Errorf("unexpected first segment:\n%s", first)
+	}
+	if !strings.Contains(second, "crosses") || strings.Contains(second, "first") || strings.Contains(second, "last") {
+		t.Errorf("unexpected second segment:\n%s", second)
+	}
+
+	fmp4 := SegmentWebVTT(cues, []MediaSegment{{URI: "360p_000.m4s", Duration: 4}}, "subs_en")
+	if !strings.HasPrefix(string(fmp4[0].Data), "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000\n") {
+		t.Errorf("unexpected fMP4 segment:\n%s", fmp4[0].Data)
+	}
+
+	playlist := string(SubtitlePlaylist(out))
+	if !strings.Contains(playlist, "#EXT-X-TARGETDURATION:4\n") || !strings.Contains(playlist, "#EXTINF:2.500000,\nsubs_en_002.vtt\n#EXT-X-ENDLIST\n") {
+		t.Fatalf("unexpected playlist:\n%s", playlist)
+	}
+}
@@ -0,0 +1,194 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenInvalid = errors.New("playback token invalid")
+	ErrTokenExpired = errors.New("playback token expired")
+)
+
+// defaultTokenTTL outlives a typical lesson, since rotated keys are fetched
+// throughout playback with the same token.
+const defaultTokenTTL = 4 * time.Hour
+
+// PlaybackClaims are the fields a playback token is bound to.
+type PlaybackClaims struct {
+	VideoID   string `json:"vid"`
+	UserID    string `json:"uid,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// TokenSigner issues and verifies HMAC-SHA256 signed playback tokens of the
+// form base64url(claims).base64url(signature).
+type TokenSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewTokenSigner(secret []byte, ttl time.Duration) *TokenSigner {
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	return &TokenSigner{secret: secret, ttl: ttl}
+}
+
+// InitTokenSigner reads the signing secret from PLAYBACK_TOKEN_SECRET and the
+// token lifetime from PLAYBACK_TOKEN_TTL (e.g. "2h"). Without a secret a
+// random one is used, so tokens stop working when the process restarts.
+func InitTokenSigner() *TokenSigner {
+	secret := []byte(os.Getenv("PLAYBACK_TOKEN_SECRET"))
+	if len(secret) == 0 {
+		log.Print("PLAYBACK_TOKEN_SECRET is not set, using a random secret")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed to generate token secret: %v", err)
+		}
+	}
+
+	var ttl time.Duration
+	if value := os.Getenv("PLAYBACK_TOKEN_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid PLAYBACK_TOKEN_TTL %q: %v", value, err)
+		}
+		ttl = parsed
+	}
+
+	return NewTokenSigner(secret, ttl)
+}
+
+// Sign issues a token for claims, setting the expiry from the signer TTL.
+func (s *TokenSigner) Sign(claims PlaybackClaims, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(s.ttl)
+	claims.ExpiresAt = expiresAt.Unix()
+
+	token, err := signClaims(s.secret, claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims.
+func (s *TokenSigner) Verify(token string, now time.Time) (*PlaybackClaims, error) {
+	var claims PlaybackClaims
+	if err := verifyClaims(s.secret, token, &claims); err != nil {
+		return nil, err
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return &claims, nil
+}
+
+// sessionType marks session tokens, so a playback token is never taken for
+// one even if both secrets are the same.
+const sessionType = "session"
+
+// SessionClaims identify the CMS user a session token was issued to.
+type SessionClaims struct {
+	Type      string `json:"typ"`
+	UserID    string `json:"uid"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SessionSigner verifies the session tokens the CMS issues to logged in
+// users, in the same format as playback tokens and signed with a secret
+// shared with the CMS.
+type SessionSigner struct {
+	secret []byte
+}
+
+func NewSessionSigner(secret []byte) *SessionSigner {
+	return &SessionSigner{secret: secret}
+}
+
+// InitSessionSigner reads the secret shared with the CMS from
+// PLAYBACK_SESSION_SECRET. Without it every session is rejected, so no
+// playback tokens can be issued.
+func InitSessionSigner() *SessionSigner {
+	secret := []byte(os.Getenv("PLAYBACK_SESSION_SECRET"))
+	if len(secret) == 0 {
+		log.Print("PLAYBACK_SESSION_SECRET is not set, playback tokens cannot be issued")
+	}
+	return NewSessionSigner(secret)
+}
+
+// Sign issues a session token for userID valid until expiresAt, as the CMS
+// does.
+func (s *SessionSigner) Sign(userID string, expiresAt time.Time) (string, error) {
+	return signClaims(s.secret, SessionClaims{Type: sessionType, UserID: userID, ExpiresAt: expiresAt.Unix()})
+}
+
+// Verify checks a session token and returns its claims. A session without a
+// user is invalid.
+func (s *SessionSigner) Verify(token string, now time.Time) (*SessionClaims, error) {
+	if len(s.secret) == 0 {
+		return nil, ErrTokenInvalid
+	}
+
+	var claims SessionClaims
+	if err := verifyClaims(s.secret, token, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Type != sessionType || claims.UserID == "" {
+		return nil, ErrTokenInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return &claims, nil
+}
+
+// signClaims encodes claims as base64url(json).base64url(HMAC-SHA256).
+func signClaims(secret []byte, claims any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(hmacSHA256(secret, encoded)), nil
+}
+
+// verifyClaims checks the signature of token and decodes its claims.
+func verifyClaims(secret []byte, token string, claims any) error {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrTokenInvalid
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, hmacSHA256(secret, encoded)) {
+		return ErrTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+func hmacSHA256(secret []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenSigner(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	token, expiresAt, err := signer.Sign(PlaybackClaims{VideoID: "vid", UserID: "u1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected expiry %v", expiresAt)
+	}
+
+	claims, err := signer.Verify(token, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.VideoID != "vid" || claims.UserID != "u1" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	if _, err := signer.Verify(token, now.Add(time.Minute)); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected expired token, got %v", err)
+	}
+
+	other := NewTokenSigner([]byte("other"), time.Minute)
+	for _, invalid := range []string{"", "abc", token + "x", "x" + token} {
+		if _, err := signer.Verify(invalid, now); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("expected invalid token for %q, got %v", invalid, err)
+		}
+	}
+	if _, err := other.Verify(token, now); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected signature mismatch, got %v", err)
+	}
+}
+
+func TestSessionSigner(t *testing.T) {
+	cms := NewSessionSigner([]byte("shared"))
+	now := time.Unix(1700000000, 0)
+
+	session, err := cms.Sign("u1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := cms.Verify(session, now)
+	if err != nil || claims.UserID != "u1" {
+		t.Fatalf("got %+v, %v; want the session of u1", claims, err)
+	}
+
+	if _, err := cms.Verify(session, now.Add(time.Minute)); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected expired session, got %v", err)
+	}
+	if _, err := NewSessionSigner([]byte("other")).Verify(session, now); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected signature mismatch, got %v", err)
+	}
+	if _, err := NewSessionSigner(nil).Verify(session, now); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected sessions rejected without a secret, got %v", err)
+	}
+
+	anonymous, err := cms.Sign("", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cms.Verify(anonymous, now); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected a session without user rejected, got %v", err)
+	}
+
+	// A playback token signed with the same secret is not a session.
+	playback, _, err := NewTokenSigner([]byte("shared"), time.Minute).Sign(PlaybackClaims{VideoID: "vid", UserID: "u1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cms.Verify(playback, now); err == nil {
+		t.Fatal("accepted a playback token as a session")
+	}
+}
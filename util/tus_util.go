@@ -0,0 +1,89 @@
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,expiration,termination"
+
+	defaultTusMaxSize = 20 << 30 // 20 GiB
+	defaultTusExpiry  = 24 * time.Hour
+)
+
+// TusConfig configures the resumable upload endpoint.
+type TusConfig struct {
+	Dir     string        // where partial uploads are kept
+	MaxSize int64         // largest accepted Upload-Length
+	Expiry  time.Duration // how long an unfinished upload may stay idle
+}
+
+// InitTusConfig reads TUS_UPLOAD_DIR, TUS_MAX_SIZE (bytes) and
+// TUS_UPLOAD_EXPIRY (e.g. "24h").
+func InitTusConfig() TusConfig {
+	config := TusConfig{
+		Dir:     os.Getenv("TUS_UPLOAD_DIR"),
+		MaxSize: defaultTusMaxSize,
+		Expiry:  defaultTusExpiry,
+	}
+	if config.Dir == "" {
+		config.Dir = filepath.Join("data", "uploads")
+	}
+
+	if value := os.Getenv("TUS_MAX_SIZE"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			log.Fatalf("Invalid TUS_MAX_SIZE %q", value)
+		}
+		config.MaxSize = size
+	}
+
+	if value := os.Getenv("TUS_UPLOAD_EXPIRY"); value != "" {
+		expiry, err := time.ParseDuration(value)
+		if err != nil || expiry <= 0 {
+			log.Fatalf("Invalid TUS_UPLOAD_EXPIRY %q", value)
+		}
+		config.Expiry = expiry
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		log.Fatalf("Failed to create upload directory: %v", err)
+	}
+
+	return config
+}
+
+// ParseTusMetadata decodes an Upload-Metadata header: comma separated pairs
+// of a key and an optional base64 encoded value.
+func ParseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(key, " ,") {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+		if _, exists := metadata[key]; exists {
+			return nil, fmt.Errorf("duplicate metadata key %q", key)
+		}
+
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q is not base64: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
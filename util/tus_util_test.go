@@ -0,0 +1,27 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	metadata, err := ParseTusMetadata("filename bGVjdHVyZSAxLm1wNA==, profile ZGVmYXVsdA==,is_confidential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"filename": "lecture 1.mp4", "profile": "default", "is_confidential": ""}
+	if !reflect.DeepEqual(metadata, want) {
+		t.Fatalf("got %v, want %v", metadata, want)
+	}
+
+	if metadata, err := ParseTusMetadata(""); err != nil || len(metadata) != 0 {
+		t.Fatalf("expected empty metadata, got %v (%v)", metadata, err)
+	}
+
+	for _, header := range []string{"filename not-base64!", "filename YQ==,filename Yg==", "filename YQ==,,profile Yg=="} {
+		if _, err := ParseTusMetadata(header); err == nil {
+			t.Errorf("expected %q to be rejected", header)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"context"
+	"ffmpeg-hls/usecase"
+	"log"
+	"time"
+)
+
+type UploadCleaner interface {
+	Run(ctx context.Context)
+}
+
+type uploadCleaner struct {
+	interval      time.Duration
+	uploadUseCase usecase.UploadUseCase
+}
+
+// NewUploadCleaner periodically removes resumable uploads that expired.
+func NewUploadCleaner(interval time.Duration, uploadUseCase usecase.UploadUseCase) UploadCleaner {
+	return &uploadCleaner{interval: interval, uploadUseCase: uploadUseCase}
+}
+
+func (c *uploadCleaner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := c.uploadUseCase.PurgeExpired(ctx)
+		if err != nil {
+			log.Printf("[UPLOAD CLEANER][ERROR] Failed to purge expired uploads: %v", err)
+		} else if purged > 0 {
+			log.Printf("[UPLOAD CLEANER] Purged %d expired uploads", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}